@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deleonn/gopr/internal/config"
+	"github.com/deleonn/gopr/internal/service"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+const configUsage = `Usage: gopr config <command> [flags]
+
+Commands:
+  init       Interactively create a config file
+  show       Print the effective configuration and where each setting came from
+  validate   Check config files for errors without generating anything
+  set-key    Save a provider's API key in the encrypted credential store
+  remove-key Delete a provider's API key from the credential store
+`
+
+// runConfig dispatches the "gopr config" subcommands
+func runConfig(args []string) {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "init":
+		runConfigInit(args[1:])
+	case "show":
+		runConfigShow(args[1:])
+	case "validate":
+		runConfigValidate(args[1:])
+	case "set-key":
+		runConfigSetKey(args[1:])
+	case "remove-key":
+		runConfigRemoveKey(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command: %s\n\n%s", args[0], configUsage)
+		os.Exit(2)
+	}
+}
+
+// runConfigShow prints the effective value and source of every setting, with
+// the API key masked or, when it is yet to be looked up, where it would come from
+func runConfigShow(args []string) {
+	fs := flag.NewFlagSet("gopr config show", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	fs.Parse(args)
+
+	resolved, err := config.Load(configFlags())
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if resolved.Profile != "" {
+		fmt.Printf("Profile: %s (%s)\n\n", resolved.Profile, resolved.ProfileSource)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, key := range config.Keys {
+		value, source := resolved.Value(key), resolved.Sources[key]
+		if key == config.KeyAPIKey {
+			// The key is only described, as looking it up may run
+			// api_key_cmd or ask for the credential store passphrase
+			value, source = resolved.DescribeAPIKey()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, source)
+	}
+	w.Flush()
+
+	if len(resolved.Config.Pricing) > 0 {
+		models := make([]string, 0, len(resolved.Config.Pricing))
+		for model := range resolved.Config.Pricing {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+
+		fmt.Println("\nPrices in US dollars per million tokens:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODEL\tINPUT\tOUTPUT\tSOURCE")
+		for _, model := range models {
+			price := resolved.Config.Pricing[model]
+			fmt.Fprintf(w, "%s\t%g\t%g\t%s\n", model, price.Input, price.Output, resolved.PricingSources[model])
+		}
+		w.Flush()
+	}
+
+	if len(resolved.Config.Capabilities) > 0 {
+		models := make([]string, 0, len(resolved.Config.Capabilities))
+		for model := range resolved.Config.Capabilities {
+			models = append(models, model)
+		}
+		sort.Strings(models)
+
+		fmt.Println("\nModel limits in tokens:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MODEL\tCONTEXT\tMAX OUTPUT\tSOURCE")
+		for _, model := range models {
+			caps := resolved.Config.Capabilities[model]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", model, tokenLimit(caps.ContextTokens), tokenLimit(caps.MaxOutputTokens), resolved.CapabilitySources[model])
+		}
+		w.Flush()
+	}
+}
+
+// tokenLimit formats a limit, which is zero when it wasn't set
+func tokenLimit(tokens int) string {
+	if tokens == 0 {
+		return "-"
+	}
+	return strconv.Itoa(tokens)
+}
+
+// runConfigValidate checks the given config files, or every file gopr would
+// load when none are given, and exits with a non-zero status on the first error
+func runConfigValidate(args []string) {
+	fs := flag.NewFlagSet("gopr config validate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gopr config validate [file...]")
+	}
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = config.Files()
+		if len(files) == 0 {
+			fmt.Println("No config files found")
+			return
+		}
+	}
+
+	for _, file := range files {
+		if err := config.ValidateFile(file); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s: ok\n", file)
+	}
+
+	if fs.NArg() == 0 {
+		// Catch errors that span files, such as an undefined default_profile
+		resolved, err := config.Load(nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		warnUnknownModel(resolved)
+	}
+}
+
+// warnUnknownModel warns when the provider doesn't offer the configured
+// model. A provider that can't be reached or can't list its models is not
+// an error of the config files, so it only gets a note.
+func warnUnknownModel(resolved *config.Resolved) {
+	if resolved.Config.Model == "" {
+		return
+	}
+	if err := resolved.ResolveAPIKey(); err != nil {
+		fmt.Fprintf(os.Stderr, "note: could not check model %s: %v\n", resolved.Config.Model, err)
+		return
+	}
+	if err := configureHTTP(resolved.Config, transport.Options{}); err != nil {
+		fmt.Fprintf(os.Stderr, "note: could not check model %s: %v\n", resolved.Config.Model, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	listed, err := service.ListModels(ctx, resolved.Config)
+	if errors.Is(err, service.ErrNoModelList) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "note: could not check model %s: %v\n", resolved.Config.Model, err)
+		return
+	}
+	if !service.ModelListed(resolved.Config.Model, listed) {
+		fmt.Fprintf(os.Stderr, "warning: %s does not offer model %s (%s), run `gopr models` to list the models it does\n",
+			resolved.Config.Provider, resolved.Config.Model, resolved.Sources[config.KeyModel])
+	}
+}
+
+// runConfigSetKey stores a provider's API key in the encrypted credential
+// store. The key is read from stdin when it is piped, or asked for on the terminal.
+func runConfigSetKey(args []string) {
+	fs := flag.NewFlagSet("gopr config set-key", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gopr config set-key <provider>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	provider := fs.Arg(0)
+
+	var key string
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice == 0 {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("Failed to read key: %v", err)
+		}
+		key = strings.TrimSpace(string(data))
+	} else {
+		var err error
+		key, err = config.ReadSecret(fmt.Sprintf("API key for %s: ", provider))
+		if err != nil {
+			log.Fatalf("Failed to read key: %v", err)
+		}
+	}
+	if key == "" {
+		log.Fatal("No key given")
+	}
+
+	passphrase, err := config.Passphrase("Passphrase for gopr credential store: ")
+	if err != nil {
+		log.Fatalf("Failed to read passphrase: %v", err)
+	}
+	if err := config.StoreCredential(provider, key, passphrase); err != nil {
+		log.Fatalf("Failed to store key: %v", err)
+	}
+
+	path, _ := config.CredentialsPath()
+	fmt.Printf("Stored key for %s in %s\n", provider, path)
+}
+
+// runConfigRemoveKey deletes a provider's API key from the credential store
+func runConfigRemoveKey(args []string) {
+	fs := flag.NewFlagSet("gopr config remove-key", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: gopr config remove-key <provider>")
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := config.RemoveCredential(fs.Arg(0)); err != nil {
+		log.Fatalf("Failed to remove key: %v", err)
+	}
+	fmt.Printf("Removed key for %s\n", fs.Arg(0))
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/aws"
+	"github.com/deleonn/gopr/internal/config"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/service"
+)
+
+const defaultOllamaURL = "http://localhost:11434"
+
+// initOptions holds the answers of the setup wizard
+type initOptions struct {
+	provider    string
+	model       string
+	apiKey      string
+	apiKeyCmd   string
+	baseURL     string
+	region      string
+	temperature string
+	output      string
+	storeKey    bool
+	test        bool
+	force       bool
+}
+
+// runConfigInit writes a config file, asking for every setting that was not
+// given as a flag unless -non-interactive is set
+func runConfigInit(args []string) {
+	fs := flag.NewFlagSet("gopr config init", flag.ExitOnError)
+	var opts initOptions
+	fs.StringVar(&opts.provider, "provider", "", "LLM provider (ollama, openai, anthropic, deepseek, openai-compatible, azure-openai, gemini, bedrock, fake, exec:<path>)")
+	fs.StringVar(&opts.model, "model", "", "Model to use (empty for the provider default)")
+	fs.StringVar(&opts.apiKey, "api-key", "", "API key for the provider")
+	fs.StringVar(&opts.apiKeyCmd, "api-key-cmd", "", "Command that prints the API key for the provider")
+	fs.StringVar(&opts.baseURL, "base-url", "", "Base URL for the provider")
+	fs.StringVar(&opts.region, "region", "", "AWS region for Bedrock")
+	fs.StringVar(&opts.temperature, "temperature", "", "Temperature for generation (default 0.1)")
+	fs.StringVar(&opts.output, "output", "", "Config file to write (default ~/.goprrc)")
+	fs.BoolVar(&opts.storeKey, "store-key", false, "Save the API key in the encrypted credential store instead of the config file")
+	fs.BoolVar(&opts.test, "test", false, "Run a test generation before writing the config")
+	fs.BoolVar(&opts.force, "force", false, "Overwrite an existing config file")
+	nonInteractive := fs.Bool("non-interactive", false, "Don't ask questions, take every setting from flags")
+	fs.Parse(args)
+
+	if opts.output == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			log.Fatalf("Failed to find home directory: %v", err)
+		}
+		opts.output = filepath.Join(homeDir, ".goprrc")
+	}
+
+	var err error
+	if *nonInteractive {
+		err = checkInitOptions(&opts)
+	} else {
+		err = askInitOptions(&opts, newPrompter())
+	}
+	if err != nil {
+		log.Fatalf("Setup failed: %v", err)
+	}
+
+	if err := writeInitConfig(opts); err != nil {
+		log.Fatalf("Failed to write config: %v", err)
+	}
+	fmt.Printf("Wrote %s\n", opts.output)
+}
+
+// checkInitOptions validates the flags of a non-interactive setup and runs the test generation if asked
+func checkInitOptions(opts *initOptions) error {
+	if opts.provider == "" {
+		return errors.New("-provider is required with -non-interactive")
+	}
+	if err := validateInitOptions(opts); err != nil {
+		return err
+	}
+	if models.ProviderType(opts.provider).RequiresAPIKey() && opts.apiKey == "" && opts.apiKeyCmd == "" {
+		return fmt.Errorf("provider %s needs -api-key or -api-key-cmd", opts.provider)
+	}
+	if !opts.force && fileExists(opts.output) {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", opts.output)
+	}
+	if opts.test {
+		reply, err := testGeneration(*opts)
+		if err != nil {
+			return fmt.Errorf("test generation failed: %w", err)
+		}
+		fmt.Printf("Test generation succeeded: %s\n", reply)
+	}
+	return nil
+}
+
+// askInitOptions asks for each setting that was not given as a flag
+func askInitOptions(opts *initOptions, p *prompter) error {
+	if opts.provider == "" {
+		names := make([]string, len(models.Providers))
+		for i, provider := range models.Providers {
+			names[i] = string(provider)
+		}
+		opts.provider = p.choose("Which provider do you want to use?", names, string(models.ProviderOllama))
+	}
+	if err := config.ValidateSetting(config.KeyProvider, opts.provider); err != nil {
+		return err
+	}
+	provider := models.ProviderType(opts.provider)
+
+	switch provider {
+	case models.ProviderOllama:
+		if opts.baseURL == "" {
+			opts.baseURL = p.ask("Ollama URL", defaultOllamaURL)
+		}
+		if opts.model == "" {
+			opts.model = askOllamaModel(p, opts.baseURL)
+		}
+	case models.ProviderBedrock:
+		// Bedrock signs requests with the AWS credentials, so there is no key to ask for
+		if opts.region == "" {
+			region, _ := aws.LoadRegion(aws.Profile())
+			opts.region = p.ask("AWS region", region)
+		}
+		if opts.model == "" {
+			opts.model = p.ask("Model ID (leave empty for the provider default)", "")
+		}
+	default:
+		if provider == models.ProviderOpenAICompatible && opts.baseURL == "" {
+			opts.baseURL = p.ask("Base URL of the server, such as http://localhost:8000/v1", "")
+		}
+		if provider == models.ProviderAzureOpenAI && opts.baseURL == "" {
+			opts.baseURL = p.ask("Resource endpoint, such as https://my-resource.openai.azure.com", "")
+		}
+		if opts.apiKey == "" && opts.apiKeyCmd == "" {
+			key, err := config.ReadSecret(fmt.Sprintf("API key for %s (leave empty to use a command instead): ", provider))
+			if err != nil {
+				return fmt.Errorf("failed to read API key: %w", err)
+			}
+			opts.apiKey = strings.TrimSpace(key)
+			if opts.apiKey == "" {
+				opts.apiKeyCmd = p.ask("Command that prints the API key", "")
+			}
+			if opts.apiKey == "" && opts.apiKeyCmd == "" && provider.RequiresAPIKey() {
+				return fmt.Errorf("provider %s needs an API key", provider)
+			}
+		}
+		if opts.apiKey != "" && !opts.storeKey {
+			opts.storeKey = p.confirm("Save the key in the encrypted credential store instead of the config file?", true)
+		}
+		if opts.model == "" && provider == models.ProviderAzureOpenAI {
+			opts.model = p.ask("Deployment name", "")
+		} else if opts.model == "" {
+			opts.model = p.ask("Model (leave empty for the provider default)", "")
+		}
+	}
+
+	if opts.temperature == "" {
+		opts.temperature = p.ask("Temperature", "0.1")
+	}
+	if err := validateInitOptions(opts); err != nil {
+		return err
+	}
+
+	if opts.test || p.confirm("Run a test generation before writing the config?", true) {
+		fmt.Println("Running a test generation...")
+		reply, err := testGeneration(*opts)
+		if err != nil {
+			fmt.Printf("Test generation failed: %v\n", err)
+			if !p.confirm("Write the config anyway?", false) {
+				return errors.New("aborted")
+			}
+		} else {
+			fmt.Printf("Test generation succeeded: %s\n", reply)
+		}
+	}
+
+	opts.output = p.ask("Config file", opts.output)
+	if !opts.force && fileExists(opts.output) && !p.confirm(fmt.Sprintf("%s already exists. Overwrite it?", opts.output), false) {
+		return errors.New("aborted")
+	}
+	return nil
+}
+
+// askOllamaModel lets the user pick one of the models installed in Ollama
+func askOllamaModel(p *prompter, baseURL string) string {
+	ollama := service.NewOllamaProvider(models.OllamaConfig{BaseURL: baseURL})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	installed, err := ollama.ListModels(ctx)
+	if err != nil {
+		fmt.Printf("Could not list the installed Ollama models: %v\n", err)
+		return p.ask("Model (leave empty for the provider default)", "")
+	}
+	if len(installed) == 0 {
+		fmt.Println("No models are installed in Ollama yet, pull one with `ollama pull <model>`.")
+		return p.ask("Model (leave empty for the provider default)", "")
+	}
+	names := make([]string, len(installed))
+	for i, m := range installed {
+		names[i] = m.Name
+	}
+	return p.choose("Which model do you want to use?", names, names[0])
+}
+
+// validateInitOptions checks the answers against the config schema
+func validateInitOptions(opts *initOptions) error {
+	settings := map[string]string{
+		config.KeyProvider:    opts.provider,
+		config.KeyBaseURL:     opts.baseURL,
+		config.KeyRegion:      opts.region,
+		config.KeyTemperature: opts.temperature,
+	}
+	if opts.temperature == "" {
+		delete(settings, config.KeyTemperature)
+	}
+	for key, value := range settings {
+		if err := config.ValidateSetting(key, value); err != nil {
+			return err
+		}
+	}
+	switch models.ProviderType(opts.provider) {
+	case models.ProviderOpenAICompatible, models.ProviderAzureOpenAI:
+		if opts.baseURL == "" {
+			return fmt.Errorf("provider %s needs a base URL", opts.provider)
+		}
+	}
+	if opts.storeKey && opts.apiKey == "" {
+		return errors.New("-store-key needs an API key")
+	}
+	return nil
+}
+
+// testGeneration sends a tiny prompt to the configured provider
+func testGeneration(opts initOptions) (string, error) {
+	temperature := 0.1
+	if opts.temperature != "" {
+		temperature, _ = strconv.ParseFloat(opts.temperature, 64)
+	}
+	cfg := models.Config{
+		Provider:    models.ProviderType(opts.provider),
+		Model:       opts.model,
+		APIKey:      opts.apiKey,
+		APIKeyCmd:   opts.apiKeyCmd,
+		BaseURL:     opts.baseURL,
+		Region:      opts.region,
+		Temperature: temperature,
+	}
+	if cfg.APIKey == "" && cfg.APIKeyCmd != "" {
+		key, err := config.RunKeyCommand(cfg.APIKeyCmd)
+		if err != nil {
+			return "", err
+		}
+		cfg.APIKey = key
+	}
+
+	provider, err := service.NewProviderFactory().CreateProvider(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	messages := []models.Message{{Role: models.RoleUser, Content: "Reply with the single word OK."}}
+	reply, err := provider.GenerateResponse(ctx, messages, temperature)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(reply.Content), nil
+}
+
+// writeInitConfig writes the answers as a key=value config file, moving the
+// key to the credential store when asked
+func writeInitConfig(opts initOptions) error {
+	if opts.storeKey {
+		passphrase, err := config.Passphrase("Passphrase for gopr credential store: ")
+		if err != nil {
+			return err
+		}
+		if err := config.StoreCredential(opts.provider, opts.apiKey, passphrase); err != nil {
+			return err
+		}
+		opts.apiKey = ""
+	}
+
+	var b strings.Builder
+	b.WriteString("# gopr configuration, written by `gopr config init`\n")
+	fmt.Fprintf(&b, "%s=%s\n", config.KeyProvider, opts.provider)
+	for _, setting := range []struct{ key, value string }{
+		{config.KeyModel, opts.model},
+		{config.KeyBaseURL, opts.baseURL},
+		{config.KeyRegion, opts.region},
+		{config.KeyAPIKey, opts.apiKey},
+		{config.KeyAPIKeyCmd, opts.apiKeyCmd},
+		{config.KeyTemperature, opts.temperature},
+	} {
+		if setting.value != "" {
+			fmt.Fprintf(&b, "%s=%s\n", setting.key, setting.value)
+		}
+	}
+	if opts.storeKey {
+		b.WriteString("# The API key is kept in the encrypted credential store\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.output), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(opts.output, []byte(b.String()), 0o600)
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// prompter asks questions on stdin
+type prompter struct {
+	in *bufio.Reader
+}
+
+func newPrompter() *prompter {
+	return &prompter{in: bufio.NewReader(os.Stdin)}
+}
+
+// ask returns the answer to a question, or def when it is left empty
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// confirm asks a yes/no question
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	fmt.Printf("%s [%s]: ", question, hint)
+	line, _ := p.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+// choose lets the user pick an option by number or by name
+func (p *prompter) choose(question string, options []string, def string) string {
+	fmt.Println(question)
+	for i, option := range options {
+		fmt.Printf("  %d) %s\n", i+1, option)
+	}
+	answer := p.ask("Choice", def)
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(options) {
+		return options[n-1]
+	}
+	return answer
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deleonn/gopr/internal/config"
+	"github.com/deleonn/gopr/internal/service"
+)
+
+// runDoctor checks the environment and the configured provider, printing a
+// fix for every failed check, and exits with a non-zero status if any failed
+func runDoctor(args []string) {
+	fs := flag.NewFlagSet("gopr doctor", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	branch := fs.String("branch", "main", "Branch for diff comparison")
+	httpFlags := registerHTTPFlags(fs)
+	fs.Parse(args)
+
+	var checks []service.Check
+	resolved, err := config.Load(configFlags())
+	if err == nil {
+		err = resolved.ResolveAPIKey()
+	}
+	if err == nil {
+		err = configureHTTP(resolved.Config, httpFlags())
+	}
+	if err != nil {
+		checks = append(checks, service.Check{
+			Name:   "config",
+			Detail: err.Error(),
+			Fix:    "fix the reported setting, `gopr config validate` checks every config file",
+		})
+	} else {
+		checks = append(checks, service.Check{Name: "config", OK: true, Detail: fmt.Sprintf("provider %s", resolved.Config.Provider)})
+		checks = append(checks, service.Diagnose(resolved.Config, *branch)...)
+	}
+
+	width := 12
+	for _, check := range checks {
+		width = max(width, len(check.Name))
+	}
+
+	failed := false
+	for _, check := range checks {
+		status := "ok"
+		if !check.OK {
+			status = "FAIL"
+			failed = true
+		}
+		fmt.Printf("[%-4s] %-*s %s\n", status, width, check.Name, check.Detail)
+		if !check.OK && check.Fix != "" {
+			fmt.Printf("       %-*s fix: %s\n", width, "", check.Fix)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
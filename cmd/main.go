@@ -1,115 +1,193 @@
 package main
 
 import (
-	"bufio"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"path/filepath"
-	"strconv"
 	"strings"
 
+	"github.com/deleonn/gopr/internal/config"
+	"github.com/deleonn/gopr/internal/git"
 	"github.com/deleonn/gopr/internal/models"
 	"github.com/deleonn/gopr/internal/service"
+	"github.com/deleonn/gopr/internal/transport"
 )
 
-func loadConfig() models.Config {
-	config := models.Config{
-		Temperature: 0.1,
-	}
-
-	// Try to load from .goprrc in current directory
-	if configFile := ".goprrc"; fileExists(configFile) {
-		loadConfigFromFile(configFile, &config)
+// registerConfigFlags adds the flags that override config settings to fs.
+// The returned function collects the flags that were explicitly set, keyed by setting name.
+func registerConfigFlags(fs *flag.FlagSet) func() map[string]string {
+	fs.String("provider", "ollama", "LLM provider (ollama, openai, anthropic, deepseek, openai-compatible, azure-openai, gemini, bedrock, fake, exec:<path>)")
+	fs.String("model", "", "Model to use")
+	fs.String("api-key", "", "API key for the provider")
+	fs.String("api-key-cmd", "", "Command that prints the API key for the provider")
+	fs.String("base-url", "", "Base URL for the provider")
+	fs.String("api-version", "", "API version for Azure OpenAI (default 2024-10-21)")
+	fs.String("region", "", "AWS region for Bedrock (default from AWS_REGION or ~/.aws/config)")
+	fs.String("headers", "", "Extra HTTP headers as a comma-separated list of \"Name: value\"")
+	fs.Float64("temperature", 0.1, "Temperature for generation")
+	fs.String("template", "", "File with the PR description format to ask for")
+	fs.Bool("structured", false, "Ask for the description as JSON with fixed sections and render the markdown in gopr")
+	fs.String("fallback", "", "Providers to try in order when the provider fails, such as \"deepseek, anthropic\"")
+	fs.String("profile", "", "Config profile to use (overrides default_profile)")
+	fs.Duration("timeout", config.DefaultTimeout, "Timeout of a request, or of the wait for a stream to start")
+	fs.Duration("connect-timeout", config.DefaultConnectTimeout, "Timeout of connecting to the provider")
+	fs.String("ca-bundle", "", "PEM file of extra certificate authorities to trust, such as a proxy's")
+
+	return func() map[string]string {
+		flags := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			key := strings.ReplaceAll(f.Name, "-", "_")
+			if key == config.KeyProfile {
+				flags[key] = f.Value.String()
+			}
+			for _, k := range config.Keys {
+				if k == key {
+					flags[key] = f.Value.String()
+				}
+			}
+		})
+		return flags
 	}
+}
 
-	// Try to load from ~/.goprrc
-	if homeDir, err := os.UserHomeDir(); err == nil {
-		if configFile := filepath.Join(homeDir, ".goprrc"); fileExists(configFile) {
-			loadConfigFromFile(configFile, &config)
+// registerHTTPFlags adds the flags that debug, record or replay the HTTP
+// traffic to fs. The returned function collects them once fs is parsed.
+func registerHTTPFlags(fs *flag.FlagSet) func() transport.Options {
+	debug := fs.Bool("debug-http", false, "Print HTTP requests and responses to stderr, with keys redacted")
+	record := fs.String("record-http", "", "Save HTTP requests and responses, with keys redacted, to a cassette file")
+	replay := fs.String("replay-http", "", "Answer HTTP requests from a cassette file instead of the network")
+
+	return func() transport.Options {
+		opts := transport.Options{Record: *record, Replay: *replay}
+		if *debug {
+			opts.Debug = os.Stderr
 		}
+		return opts
 	}
-
-	return config
 }
 
-func fileExists(filename string) bool {
-	_, err := os.Stat(filename)
-	return err == nil
+// configureHTTP sets up the transport shared by every provider, with the
+// timeouts and CA bundle of cfg. The keys of cfg are kept out of debug
+// output and recordings.
+func configureHTTP(cfg models.Config, opts transport.Options) error {
+	opts.Timeout = cfg.Timeout
+	opts.ConnectTimeout = cfg.ConnectTimeout
+	opts.CABundle = cfg.CABundle
+	opts.Secrets = append(opts.Secrets, cfg.APIKey)
+	for _, key := range cfg.APIKeys {
+		opts.Secrets = append(opts.Secrets, key)
+	}
+	for _, value := range cfg.Headers {
+		opts.Secrets = append(opts.Secrets, value)
+	}
+	return transport.Configure(opts)
 }
 
-func loadConfigFromFile(filename string, config *models.Config) {
-	file, err := os.Open(filename)
+// applyOverrides applies the override rule matching the current branch and
+// the files changed since branch
+func applyOverrides(resolved *config.Resolved, branch string, verbose bool) error {
+	currentBranch, err := git.CurrentBranch()
 	if err != nil {
-		return
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+	changedFiles, err := git.ChangedFiles(branch)
+	if err != nil {
+		return fmt.Errorf("failed to get changed files: %w", err)
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
 
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-
-		switch key {
-		case "provider":
-			config.Provider = models.ProviderType(value)
-		case "model":
-			config.Model = value
-		case "api_key":
-			config.APIKey = value
-		case "base_url":
-			config.BaseURL = value
-		case "temperature":
-			if temp, err := strconv.ParseFloat(value, 64); err == nil {
-				config.Temperature = temp
-			}
+	rule, err := resolved.ApplyOverrides(currentBranch, changedFiles)
+	if err != nil {
+		return err
+	}
+	if verbose {
+		if rule != nil {
+			fmt.Fprintf(os.Stderr, "Config override: %s (%s)\n", rule.Name, rule.Source)
+		} else {
+			fmt.Fprintf(os.Stderr, "Config override: none matched\n")
 		}
 	}
+	return nil
 }
 
 func main() {
-	config := loadConfig()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "doctor":
+			runDoctor(os.Args[2:])
+			return
+		case "usage":
+			runUsage(os.Args[2:])
+			return
+		case "models":
+			runModels(os.Args[2:])
+			return
+		}
+	}
 
-	// Parse command line flags (these override config file)
+	fs := flag.NewFlagSet("gopr", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	httpFlags := registerHTTPFlags(fs)
 	var (
-		provider    = flag.String("provider", string(config.Provider), "LLM provider (ollama, openai, anthropic, deepseek)")
-		model       = flag.String("model", config.Model, "Model to use")
-		apiKey      = flag.String("api-key", config.APIKey, "API key for the provider")
-		baseURL     = flag.String("base-url", config.BaseURL, "Base URL for the provider")
-		temperature = flag.Float64("temperature", config.Temperature, "Temperature for generation")
-		branch      = flag.String("branch", "main", "Branch for diff comparison")
-		verbose     = flag.Bool("verbose", false, "Enable verbose output")
+		branch   = fs.String("branch", "main", "Branch for diff comparison")
+		verbose  = fs.Bool("verbose", false, "Enable verbose output")
+		noStream = fs.Bool("no-stream", false, "Print the description only once it is complete")
+		asJSON   = fs.Bool("json", false, "Print the description as JSON, asking for structured output")
 	)
-	flag.Parse()
+	fs.Parse(os.Args[1:])
 
-	// Override config with command line flags
-	config.Provider = models.ProviderType(*provider)
-	config.Model = *model
-	config.APIKey = *apiKey
-	config.BaseURL = *baseURL
-	config.Temperature = *temperature
+	resolved, err := config.Load(configFlags())
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if resolved.HasOverrides() {
+		if err := applyOverrides(resolved, *branch, *verbose); err != nil {
+			log.Fatalf("Failed to apply config overrides: %v", err)
+		}
+	}
+	if err := resolved.ResolveAPIKey(); err != nil {
+		log.Fatalf("Failed to get API key: %v", err)
+	}
+	if err := configureHTTP(resolved.Config, httpFlags()); err != nil {
+		log.Fatalf("Failed to configure HTTP: %v", err)
+	}
 
-	prService, err := service.NewPRService(config, *branch)
+	if *asJSON {
+		resolved.Config.Structured = true
+	}
+	prService, err := service.NewPRService(resolved.Config, *branch)
 	if err != nil {
 		log.Fatalf("Failed to create PR service: %v", err)
 	}
 
+	if *asJSON {
+		description, err := prService.GenerateStructuredPRDescription(*verbose)
+		if err != nil {
+			log.Fatalf("Failed to generate PR description: %v", err)
+		}
+		out, err := json.MarshalIndent(description, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to marshal PR description: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	if !*noStream {
+		prService.StreamTo(os.Stdout)
+	}
+
 	description, err := prService.GeneratePRDescriptionFromBranch(*verbose)
 	if err != nil {
 		log.Fatalf("Failed to generate PR description: %v", err)
 	}
 
 	// Output the description to stdout (can be piped to gh or clipboard)
-	fmt.Print(description)
+	if *noStream {
+		fmt.Print(description)
+	}
 }
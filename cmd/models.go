@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deleonn/gopr/internal/config"
+	"github.com/deleonn/gopr/internal/service"
+)
+
+// runModels prints the models the configured provider offers, marking the
+// configured one
+func runModels(args []string) {
+	fs := flag.NewFlagSet("gopr models", flag.ExitOnError)
+	configFlags := registerConfigFlags(fs)
+	httpFlags := registerHTTPFlags(fs)
+	fs.Parse(args)
+
+	resolved, err := config.Load(configFlags())
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if err := resolved.ResolveAPIKey(); err != nil {
+		log.Fatalf("Failed to get API key: %v", err)
+	}
+	if err := configureHTTP(resolved.Config, httpFlags()); err != nil {
+		log.Fatalf("Failed to configure HTTP: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	listed, err := service.ListModels(ctx, resolved.Config)
+	if err != nil {
+		log.Fatalf("Failed to list models: %v", err)
+	}
+	if len(listed) == 0 {
+		fmt.Printf("%s offers no models\n", resolved.Config.Provider)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tSIZE\tCONTEXT")
+	for i, m := range listed {
+		size, window := "-", "-"
+		if m.Size > 0 {
+			size = formatSize(m.Size)
+		}
+		if m.ContextTokens > 0 {
+			window = strconv.Itoa(m.ContextTokens)
+		}
+		if service.ModelListed(resolved.Config.Model, listed[i:i+1]) {
+			fmt.Fprintf(w, "%s\t%s\t%s\t(configured)\n", m.Name, size, window)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", m.Name, size, window)
+	}
+	w.Flush()
+}
+
+// formatSize formats a size in bytes like Ollama does, such as "4.7 GB"
+func formatSize(bytes int64) string {
+	const unit = 1000
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exp := float64(bytes)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "kMGT"[exp])
+}
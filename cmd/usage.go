@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/deleonn/gopr/internal/usage"
+)
+
+// usageGroupings are the ways the usage ledger can be summarised, in the order they are printed
+var usageGroupings = []struct {
+	name string
+	key  func(usage.Entry) string
+}{
+	{"day", func(e usage.Entry) string { return e.Time.Local().Format(time.DateOnly) }},
+	{"model", func(e usage.Entry) string { return e.Provider + "/" + e.Model }},
+	{"repo", func(e usage.Entry) string {
+		if e.Repo == "" {
+			return "(unknown)"
+		}
+		return e.Repo
+	}},
+}
+
+// runUsage summarises the token usage and estimated cost recorded in the
+// usage ledger by day, model and repo
+func runUsage(args []string) {
+	fs := flag.NewFlagSet("gopr usage", flag.ExitOnError)
+	by := fs.String("by", "", "Only summarise by day, model or repo")
+	since := fs.String("since", "", "Only count runs on or after this date (YYYY-MM-DD)")
+	fs.Parse(args)
+
+	path, err := usage.LedgerPath()
+	if err != nil {
+		log.Fatalf("Failed to locate usage ledger: %v", err)
+	}
+	entries, err := usage.ReadLedger(path)
+	if err != nil {
+		log.Fatalf("Failed to read usage ledger: %v", err)
+	}
+
+	if *since != "" {
+		start, err := time.ParseInLocation(time.DateOnly, *since, time.Local)
+		if err != nil {
+			log.Fatalf("Invalid -since %q: expected a date such as 2024-06-01", *since)
+		}
+		var recent []usage.Entry
+		for _, entry := range entries {
+			if !entry.Time.Before(start) {
+				recent = append(recent, entry)
+			}
+		}
+		entries = recent
+	}
+
+	groupings := usageGroupings
+	if *by != "" {
+		groupings = nil
+		for _, g := range usageGroupings {
+			if g.name == *by {
+				groupings = append(groupings, g)
+			}
+		}
+		if len(groupings) == 0 {
+			fmt.Fprintf(os.Stderr, "invalid -by %q: expected day, model or repo\n", *by)
+			os.Exit(2)
+		}
+	}
+
+	if len(entries) == 0 {
+		fmt.Printf("No usage recorded in %s\n", path)
+		return
+	}
+
+	var total usage.Summary
+	for _, entry := range entries {
+		total.Add(entry)
+	}
+
+	for i, g := range groupings {
+		if i > 0 {
+			fmt.Println()
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tRUNS\tINPUT TOKENS\tOUTPUT TOKENS\tEST. COST\n", strings.ToUpper(g.name))
+		for _, s := range usage.Summarize(entries, g.key) {
+			printSummary(w, s.Key, s)
+		}
+		printSummary(w, "total", total)
+		w.Flush()
+	}
+
+	if total.Unpriced > 0 {
+		fmt.Printf("\n* %d of %d runs are not counted in the cost, as the provider reported no usage or the model\nhas no price. Set prices in a [pricing.<model>] section of a config file.\n", total.Unpriced, total.Runs)
+	}
+}
+
+// printSummary writes a row of a usage table
+func printSummary(w *tabwriter.Writer, key string, s usage.Summary) {
+	cost := fmt.Sprintf("$%.4f", s.Cost)
+	if s.Unpriced > 0 {
+		cost += "*"
+	}
+	fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", key, s.Runs, s.InputTokens, s.OutputTokens, cost)
+}
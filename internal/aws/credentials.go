@@ -0,0 +1,152 @@
+// Package aws resolves AWS credentials and signs requests without the AWS SDK
+package aws
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials are the static keys used to sign requests
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set for temporary credentials
+	SessionToken string
+}
+
+// Profile returns the AWS profile to use, from AWS_PROFILE or "default"
+func Profile() string {
+	if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// LoadCredentials resolves credentials the way the AWS CLI does for static
+// keys: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, then the profile's
+// section of the shared credentials file, then of the shared config file.
+// Profiles that use SSO or a credential_process are reported as unsupported.
+func LoadCredentials(profile string) (Credentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return Credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	var unsupported string
+	for _, source := range []struct{ file, section string }{
+		{credentialsFile(), profile},
+		{configFile(), configSection(profile)},
+	} {
+		values, err := readSection(source.file, source.section)
+		if err != nil {
+			return Credentials{}, err
+		}
+		if values["aws_access_key_id"] != "" && values["aws_secret_access_key"] != "" {
+			return Credentials{
+				AccessKeyID:     values["aws_access_key_id"],
+				SecretAccessKey: values["aws_secret_access_key"],
+				SessionToken:    values["aws_session_token"],
+			}, nil
+		}
+		unsupported = cmp.Or(unsupported, unsupportedSource(values))
+	}
+
+	if unsupported != "" {
+		return Credentials{}, fmt.Errorf("profile %q gets its credentials from %s, which gopr does not support: export them with `aws configure export-credentials --profile %s --format env`, or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY", profile, unsupported, profile)
+	}
+	return Credentials{}, fmt.Errorf("no AWS credentials found for profile %q: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or add the profile to %s", profile, credentialsFile())
+}
+
+// unsupportedSource names the credential source of a profile section that
+// needs the AWS SDK, IAM Identity Center or a credential_process, or returns ""
+func unsupportedSource(values map[string]string) string {
+	if values["credential_process"] != "" {
+		return "credential_process"
+	}
+	for key := range values {
+		if strings.HasPrefix(key, "sso_") {
+			return "IAM Identity Center (sso_* settings)"
+		}
+	}
+	return ""
+}
+
+// LoadRegion resolves the region from AWS_REGION, AWS_DEFAULT_REGION or the
+// profile's section of the shared config file, returning "" when none is set
+func LoadRegion(profile string) (string, error) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(name); region != "" {
+			return region, nil
+		}
+	}
+	values, err := readSection(configFile(), configSection(profile))
+	if err != nil {
+		return "", err
+	}
+	return values["region"], nil
+}
+
+// credentialsFile returns the path of the shared credentials file
+func credentialsFile() string {
+	if file := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); file != "" {
+		return file
+	}
+	return filepath.Join(awsDir(), "credentials")
+}
+
+// configFile returns the path of the shared config file
+func configFile() string {
+	if file := os.Getenv("AWS_CONFIG_FILE"); file != "" {
+		return file
+	}
+	return filepath.Join(awsDir(), "config")
+}
+
+func awsDir() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".aws")
+}
+
+// configSection returns the section name of a profile in the shared config
+// file, where every profile but the default one is prefixed with "profile "
+func configSection(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// readSection returns the key/value pairs of a section of an INI file. A
+// missing file has no sections.
+func readSection(filename, section string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+
+	values := make(map[string]string)
+	inSection := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.Join(strings.Fields(line[1:len(line)-1]), " ") == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,80 @@
+package aws
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	credentials := `[default]
+aws_access_key_id = AKIDDEFAULT
+aws_secret_access_key = default-secret
+`
+	config := `[profile dev]
+aws_access_key_id = AKIDDEV
+aws_secret_access_key = dev-secret
+aws_session_token = dev-token
+
+[profile sso]
+sso_session = my-sso
+sso_account_id = 111122223333
+sso_role_name = Developer
+
+[profile process]
+credential_process = /opt/bin/aws-creds --role dev
+`
+	tests := []struct {
+		name    string
+		profile string
+		env     map[string]string
+		want    Credentials
+		wantErr string
+	}{
+		{
+			name:    "environment",
+			profile: "dev",
+			env:     map[string]string{"AWS_ACCESS_KEY_ID": "AKIDENV", "AWS_SECRET_ACCESS_KEY": "env-secret"},
+			want:    Credentials{AccessKeyID: "AKIDENV", SecretAccessKey: "env-secret"},
+		},
+		{name: "credentials file", profile: "default", want: Credentials{AccessKeyID: "AKIDDEFAULT", SecretAccessKey: "default-secret"}},
+		{name: "config file", profile: "dev", want: Credentials{AccessKeyID: "AKIDDEV", SecretAccessKey: "dev-secret", SessionToken: "dev-token"}},
+		{
+			name:    "sso",
+			profile: "sso",
+			wantErr: `profile "sso" gets its credentials from IAM Identity Center (sso_* settings), which gopr does not support: export them with ` + "`aws configure export-credentials --profile sso --format env`" + `, or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY`,
+		},
+		{
+			name:    "credential_process",
+			profile: "process",
+			wantErr: `profile "process" gets its credentials from credential_process, which gopr does not support: export them with ` + "`aws configure export-credentials --profile process --format env`" + `, or set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY`,
+		},
+		{name: "missing profile", profile: "prod", wantErr: `no AWS credentials found for profile "prod"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range map[string]string{"credentials": credentials, "config": config} {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+			for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN"} {
+				t.Setenv(name, tt.env[name])
+			}
+
+			got, err := LoadCredentials(tt.profile)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("LoadCredentials(%q): %v", tt.profile, err)
+			case tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)):
+				t.Fatalf("LoadCredentials(%q) error = %v, want %q", tt.profile, err, tt.wantErr)
+			case got != tt.want:
+				t.Errorf("LoadCredentials(%q) = %+v, want %+v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// maxMessageSize bounds the size of a single event stream message
+const maxMessageSize = 16 * 1024 * 1024
+
+// Message is a single message of an application/vnd.amazon.eventstream response
+type Message struct {
+	// Headers holds the string headers, such as :event-type and :message-type
+	Headers map[string]string
+	Payload []byte
+}
+
+// EventStreamReader decodes the binary event stream encoding used by
+// streaming AWS APIs
+type EventStreamReader struct {
+	r *bufio.Reader
+}
+
+func NewEventStreamReader(r io.Reader) *EventStreamReader {
+	return &EventStreamReader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next message, or io.EOF at the end of the stream. Each
+// message is a prelude with its total and header lengths, the headers, the
+// payload and a CRC32 of everything before it.
+func (e *EventStreamReader) Next() (*Message, error) {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(e.r, prelude); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated event stream message")
+		}
+		return nil, err
+	}
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, fmt.Errorf("event stream prelude checksum mismatch")
+	}
+	if totalLen > maxMessageSize || totalLen < 16 || headersLen > totalLen-16 {
+		return nil, fmt.Errorf("invalid event stream message length %d", totalLen)
+	}
+
+	message := make([]byte, totalLen)
+	copy(message, prelude)
+	if _, err := io.ReadFull(e.r, message[12:]); err != nil {
+		return nil, fmt.Errorf("truncated event stream message")
+	}
+	if crc32.ChecksumIEEE(message[:totalLen-4]) != binary.BigEndian.Uint32(message[totalLen-4:]) {
+		return nil, fmt.Errorf("event stream message checksum mismatch")
+	}
+
+	headers, err := decodeHeaders(message[12 : 12+headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Headers: headers, Payload: message[12+headersLen : totalLen-4]}, nil
+}
+
+// Sizes of the fixed-size header value types, indexed by type
+var headerValueSizes = map[byte]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 4, 5: 8, 8: 8, 9: 16}
+
+// decodeHeaders reads the string headers of a message, skipping values of other types
+func decodeHeaders(data []byte) (map[string]string, error) {
+	invalid := errors.New("invalid event stream headers")
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		nameLen := int(data[0])
+		if len(data) < 1+nameLen+1 {
+			return nil, invalid
+		}
+		name := string(data[1 : 1+nameLen])
+		valueType := data[1+nameLen]
+		data = data[2+nameLen:]
+
+		switch valueType {
+		case 6, 7:
+			// Byte array and string values are prefixed with their length
+			if len(data) < 2 {
+				return nil, invalid
+			}
+			valueLen := int(binary.BigEndian.Uint16(data))
+			if len(data) < 2+valueLen {
+				return nil, invalid
+			}
+			if valueType == 7 {
+				headers[name] = string(data[2 : 2+valueLen])
+			}
+			data = data[2+valueLen:]
+		default:
+			size, ok := headerValueSizes[valueType]
+			if !ok || len(data) < size {
+				return nil, invalid
+			}
+			data = data[size:]
+		}
+	}
+	return headers, nil
+}
@@ -0,0 +1,115 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"maps"
+	"testing"
+)
+
+// chunkFrame is a chunk event of a Bedrock InvokeModelWithResponseStream
+// response, whose payload holds a base64-encoded Anthropic content_block_delta
+// and the padding Bedrock adds in "p"
+const chunkFrame = "000000f40000004b3998b1ec0b3a6576656e742d747970650700056368756e6b" +
+	"0d3a636f6e74656e742d747970650700106170706c69636174696f6e2f6a736f" +
+	"6e0d3a6d6573736167652d747970650700056576656e747b226279746573223a" +
+	"2265794a306558426c496a6f695932397564475675644639696247396a613139" +
+	"6b5a57783059534973496d6c755a475634496a6f774c434a6b5a577830595349" +
+	"3665794a306558426c496a6f69644756346446396b5a57783059534973496e52" +
+	"6c654851694f694a425a47527a4947456762476c755a534a3966513d3d222c22" +
+	"70223a226162636465666768696a227dd9b9569d"
+
+const chunkPayload = `{"bytes":"eyJ0eXBlIjoiY29udGVudF9ibG9ja19kZWx0YSIsImluZGV4IjowLCJkZWx0YSI6eyJ0eXBlIjoidGV4dF9kZWx0YSIsInRleHQiOiJBZGRzIGEgbGluZSJ9fQ==","p":"abcdefghij"}`
+
+func decodeFrame(t *testing.T) []byte {
+	t.Helper()
+	frame, err := hex.DecodeString(chunkFrame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestEventStreamReader(t *testing.T) {
+	frame := decodeFrame(t)
+	r := NewEventStreamReader(bytes.NewReader(append(frame, frame...)))
+
+	for i := range 2 {
+		message, err := r.Next()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		wantHeaders := map[string]string{":event-type": "chunk", ":content-type": "application/json", ":message-type": "event"}
+		if !maps.Equal(message.Headers, wantHeaders) {
+			t.Errorf("headers = %v, want %v", message.Headers, wantHeaders)
+		}
+		if string(message.Payload) != chunkPayload {
+			t.Errorf("payload = %s, want %s", message.Payload, chunkPayload)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("error at the end of the stream = %v, want io.EOF", err)
+	}
+}
+
+func TestEventStreamReaderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(frame []byte) []byte
+		want    string
+	}{
+		{
+			name:    "payload checksum",
+			corrupt: func(frame []byte) []byte { frame[len(frame)-10] ^= 0xff; return frame },
+			want:    "event stream message checksum mismatch",
+		},
+		{
+			name:    "prelude checksum",
+			corrupt: func(frame []byte) []byte { frame[3]++; return frame },
+			want:    "event stream prelude checksum mismatch",
+		},
+		{
+			name:    "truncated message",
+			corrupt: func(frame []byte) []byte { return frame[:100] },
+			want:    "truncated event stream message",
+		},
+		{
+			name:    "truncated prelude",
+			corrupt: func(frame []byte) []byte { return frame[:8] },
+			want:    "truncated event stream message",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewEventStreamReader(bytes.NewReader(tt.corrupt(decodeFrame(t))))
+			if _, err := r.Next(); err == nil || err.Error() != tt.want {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHeaders(t *testing.T) {
+	// A bool, a timestamp and a byte array header around a string one
+	data := []byte{
+		5, 'f', 'l', 'a', 'g', 's', 0,
+		5, ':', 'd', 'a', 't', 'e', 8, 0, 0, 1, 0x92, 0x8c, 0x2e, 0x60, 0,
+		11, ':', 'e', 'v', 'e', 'n', 't', '-', 't', 'y', 'p', 'e', 7, 0, 5, 'c', 'h', 'u', 'n', 'k',
+		2, 'i', 'd', 6, 0, 2, 0xca, 0xfe,
+	}
+	headers, err := decodeHeaders(data)
+	if err != nil {
+		t.Fatalf("decodeHeaders: %v", err)
+	}
+	if want := map[string]string{":event-type": "chunk"}; !maps.Equal(headers, want) {
+		t.Errorf("headers = %v, want %v", headers, want)
+	}
+
+	for _, n := range []int{1, 9, 20, len(data) - 1} {
+		if _, err := decodeHeaders(data[:n]); err == nil {
+			t.Errorf("decodeHeaders of %d bytes succeeded, want an error", n)
+		}
+	}
+}
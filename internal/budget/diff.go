@@ -0,0 +1,322 @@
+package budget
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Kinds of changes, from the least to the most useful for describing a
+// branch. Hunks of the lowest kinds are omitted first.
+const (
+	kindLockfile = iota
+	kindGenerated
+	kindVendored
+	kindDeletedFile
+	kindDeletionsOnly
+	kindTest
+	kindCode
+)
+
+// kindNames describe why a hunk was omitted
+var kindNames = map[int]string{
+	kindLockfile:      "lockfile",
+	kindGenerated:     "generated",
+	kindVendored:      "vendored",
+	kindDeletedFile:   "deleted file",
+	kindDeletionsOnly: "only deletions",
+	kindTest:          "test",
+	kindCode:          "largest hunks",
+}
+
+// lockfiles are dependency lock files, which say little about a change
+var lockfiles = map[string]bool{
+	"go.sum":            true,
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"Cargo.lock":        true,
+	"poetry.lock":       true,
+	"Pipfile.lock":      true,
+	"composer.lock":     true,
+	"Gemfile.lock":      true,
+	"uv.lock":           true,
+}
+
+// generatedSuffixes end the names of generated files
+var generatedSuffixes = []string{".pb.go", "_generated.go", ".gen.go", "_gen.go", ".min.js", ".min.css", ".snap", ".map"}
+
+// vendoredDirs are directories of third-party or built code
+var vendoredDirs = []string{"vendor/", "node_modules/", "third_party/", "dist/", "build/"}
+
+// Omission describes what was left out of a file's diff
+type Omission struct {
+	Path string
+	// Reason is the kind of change, such as "lockfile" or "test"
+	Reason string
+	Hunks  int
+	Lines  int
+	// WholeFile is set when even the file's header was left out
+	WholeFile bool
+}
+
+func (o Omission) String() string {
+	if o.WholeFile {
+		return fmt.Sprintf("%s: entire file, %d lines (%s)", o.Path, o.Lines, o.Reason)
+	}
+	return fmt.Sprintf("%s: %d %s, %d lines (%s)", o.Path, o.Hunks, plural(o.Hunks, "hunk"), o.Lines, o.Reason)
+}
+
+// diffFile is the diff of a single file, split into its header and hunks
+type diffFile struct {
+	path   string
+	kind   int
+	header string
+	hunks  []*hunk
+	// dropped is set when the header was left out as well
+	dropped bool
+}
+
+type hunk struct {
+	text    string
+	lines   int
+	kind    int
+	tokens  int
+	dropped bool
+}
+
+// FitDiff leaves hunks out of diff until its estimated size fits in
+// budget tokens. Lockfiles go first, then generated and vendored files,
+// deleted files, hunks that only delete lines, tests and finally the rest,
+// the largest hunks first within each kind. Files whose hunks were all
+// omitted keep their header with a note, unless even the headers don't fit.
+// A budget of zero or less keeps the whole diff.
+func FitDiff(diff string, budget int) (string, []Omission) {
+	if budget <= 0 || EstimateTokens(diff) <= budget {
+		return diff, nil
+	}
+
+	files := parseDiff(diff)
+	total := 0
+	var hunks []*hunk
+	for _, f := range files {
+		total += EstimateTokens(f.header)
+		for _, h := range f.hunks {
+			total += h.tokens
+			hunks = append(hunks, h)
+		}
+	}
+
+	sort.SliceStable(hunks, func(i, j int) bool {
+		if hunks[i].kind != hunks[j].kind {
+			return hunks[i].kind < hunks[j].kind
+		}
+		return hunks[i].tokens > hunks[j].tokens
+	})
+	for _, h := range hunks {
+		if total <= budget {
+			break
+		}
+		h.dropped = true
+		total -= h.tokens
+	}
+
+	// The notes replacing omitted hunks cost a little too, and when they
+	// and the headers still don't fit, whole files go
+	for _, f := range files {
+		total += f.noteTokens()
+	}
+	if total > budget {
+		byKind := append([]*diffFile(nil), files...)
+		sort.SliceStable(byKind, func(i, j int) bool {
+			return byKind[i].kind < byKind[j].kind
+		})
+		for _, f := range byKind {
+			if total <= budget {
+				break
+			}
+			f.dropped = true
+			total -= EstimateTokens(f.header) + f.noteTokens()
+			for _, h := range f.hunks {
+				if !h.dropped {
+					total -= h.tokens
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	var omitted []Omission
+	for _, f := range files {
+		if f.dropped {
+			lines := strings.Count(f.header, "\n")
+			for _, h := range f.hunks {
+				lines += h.lines
+			}
+			omitted = append(omitted, Omission{Path: f.path, Reason: kindNames[f.kind], Hunks: len(f.hunks), Lines: lines, WholeFile: true})
+			continue
+		}
+
+		b.WriteString(f.header)
+		omission := Omission{Path: f.path}
+		kind := kindLockfile
+		for _, h := range f.hunks {
+			if !h.dropped {
+				b.WriteString(h.text)
+				continue
+			}
+			omission.Hunks++
+			omission.Lines += h.lines
+			kind = max(kind, h.kind)
+		}
+		omission.Reason = kindNames[kind]
+		if omission.Hunks > 0 {
+			b.WriteString(f.note())
+			omitted = append(omitted, omission)
+		}
+	}
+	return b.String(), omitted
+}
+
+// omittedHunks counts the hunks of the file that were left out
+func (f *diffFile) omittedHunks() int {
+	n := 0
+	for _, h := range f.hunks {
+		if h.dropped {
+			n++
+		}
+	}
+	return n
+}
+
+// noteTokens estimates the size of the note, zero when no hunk was omitted
+func (f *diffFile) noteTokens() int {
+	if f.omittedHunks() == 0 {
+		return 0
+	}
+	return EstimateTokens(f.note())
+}
+
+// note stands in for the omitted hunks of the file
+func (f *diffFile) note() string {
+	n := f.omittedHunks()
+	return fmt.Sprintf("[%d %s omitted to fit the context window]\n", n, plural(n, "hunk"))
+}
+
+// parseDiff splits a git diff into files and hunks
+func parseDiff(diff string) []*diffFile {
+	var files []*diffFile
+	var current *diffFile
+	var h *hunk
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if line == "" {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = &diffFile{path: diffPath(line)}
+			current.kind = fileKind(current.path)
+			files = append(files, current)
+			h = nil
+		case current == nil:
+			// Text before the first file, kept as a header of its own
+			current = &diffFile{kind: kindCode}
+			files = append(files, current)
+		case strings.HasPrefix(line, "@@"):
+			h = &hunk{kind: current.kind}
+			current.hunks = append(current.hunks, h)
+		}
+
+		if h == nil {
+			current.header += line
+			if strings.HasPrefix(line, "deleted file mode") && current.kind > kindDeletedFile {
+				current.kind = kindDeletedFile
+			}
+			continue
+		}
+		h.text += line
+		h.lines++
+	}
+
+	for _, f := range files {
+		for _, h := range f.hunks {
+			h.kind = f.kind
+			if h.kind > kindDeletionsOnly && deletionsOnly(h.text) {
+				h.kind = kindDeletionsOnly
+			}
+			h.tokens = EstimateTokens(h.text)
+		}
+	}
+	return files
+}
+
+// diffPath reads the path of the changed file from a "diff --git a/x b/x" line
+func diffPath(line string) string {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "diff --git "))
+	if i := strings.LastIndex(line, " b/"); i >= 0 {
+		return line[i+3:]
+	}
+	return line
+}
+
+// fileKind classifies a file by its path
+func fileKind(filePath string) int {
+	base := path.Base(filePath)
+	switch {
+	case lockfiles[base]:
+		return kindLockfile
+	case hasAnySuffix(base, generatedSuffixes):
+		return kindGenerated
+	}
+	for _, dir := range vendoredDirs {
+		if strings.HasPrefix(filePath, dir) || strings.Contains(filePath, "/"+dir) {
+			return kindVendored
+		}
+	}
+	if isTest(filePath) {
+		return kindTest
+	}
+	return kindCode
+}
+
+// isTest reports whether a path looks like a test file
+func isTest(filePath string) bool {
+	base := path.Base(filePath)
+	name := strings.TrimSuffix(base, path.Ext(base))
+	return strings.HasSuffix(name, "_test") || strings.HasPrefix(name, "test_") ||
+		strings.HasSuffix(name, ".test") || strings.HasSuffix(name, ".spec") ||
+		strings.Contains("/"+filePath, "/test/") || strings.Contains("/"+filePath, "/tests/") ||
+		strings.Contains("/"+filePath, "/__tests__/")
+}
+
+// deletionsOnly reports whether a hunk removes lines without adding any
+func deletionsOnly(text string) bool {
+	deleted := false
+	for _, line := range strings.Split(text, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+"):
+			return false
+		case strings.HasPrefix(line, "-"):
+			deleted = true
+		}
+	}
+	return deleted
+}
+
+func hasAnySuffix(s string, suffixes []string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,169 @@
+package budget
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testFile builds the diff of one file with a hunk per count of lines,
+// each line added, or removed when deleted is set
+func testFile(path string, deleted bool, hunks ...int) (header string, texts []string) {
+	header = fmt.Sprintf("diff --git a/%s b/%s\n", path, path)
+	if deleted {
+		header += "deleted file mode 100644\n"
+	}
+	header += fmt.Sprintf("--- a/%s\n+++ b/%s\n", path, path)
+	sign := "+"
+	if deleted {
+		sign = "-"
+	}
+	for i, lines := range hunks {
+		text := fmt.Sprintf("@@ -%d,0 +%d,%d @@\n", i*100, i*100, lines)
+		for j := range lines {
+			text += fmt.Sprintf("%s\tvalue%d := compute(%d, %q)\n", sign, j, j, path)
+		}
+		texts = append(texts, text)
+	}
+	return header, texts
+}
+
+func TestFitDiff(t *testing.T) {
+	type file struct {
+		path    string
+		deleted bool
+		hunks   []int
+	}
+	// In the order they are omitted, except main.go whose largest hunk goes first
+	files := []file{
+		{path: "go.sum", hunks: []int{20}},
+		{path: "api/api.pb.go", hunks: []int{20}},
+		{path: "vendor/lib/lib.go", hunks: []int{20}},
+		{path: "old.go", deleted: true, hunks: []int{20}},
+		{path: "util.go", hunks: []int{20}},
+		{path: "main_test.go", hunks: []int{20}},
+		{path: "main.go", hunks: []int{20, 2}},
+	}
+
+	var diff strings.Builder
+	hunks := map[string][]string{}
+	for _, f := range files {
+		header, texts := testFile(f.path, f.deleted, f.hunks...)
+		if f.path == "util.go" {
+			// A hunk that only deletes lines of a file that stays
+			texts = []string{strings.ReplaceAll(texts[0], "+\t", "-\t")}
+		}
+		diff.WriteString(header + strings.Join(texts, ""))
+		hunks[f.path] = texts
+	}
+	total := EstimateTokens(diff.String())
+	note := EstimateTokens("[1 hunk omitted to fit the context window]\n")
+
+	// tokensOf sums the first hunk of each file
+	tokensOf := func(paths ...string) int {
+		n := 0
+		for _, p := range paths {
+			n += EstimateTokens(hunks[p][0])
+		}
+		return n
+	}
+
+	tests := []struct {
+		name        string
+		budget      int
+		wantOmitted []string
+		// wantKept and wantDropped are hunks expected in and out of the result
+		wantKept    []string
+		wantDropped []string
+	}{
+		{name: "fits", budget: total, wantKept: []string{hunks["go.sum"][0], hunks["main.go"][0]}},
+		{name: "no budget", budget: 0, wantKept: []string{hunks["go.sum"][0]}},
+		{
+			name:        "lockfile first",
+			budget:      total - 1,
+			wantOmitted: []string{"go.sum: 1 hunk, 21 lines (lockfile)"},
+			wantKept:    []string{hunks["api/api.pb.go"][0]},
+			wantDropped: []string{hunks["go.sum"][0]},
+		},
+		{
+			name:   "everything but code",
+			budget: total - tokensOf("go.sum", "api/api.pb.go", "vendor/lib/lib.go", "old.go", "util.go", "main_test.go") + 6*note,
+			wantOmitted: []string{
+				"go.sum: 1 hunk, 21 lines (lockfile)",
+				"api/api.pb.go: 1 hunk, 21 lines (generated)",
+				"vendor/lib/lib.go: 1 hunk, 21 lines (vendored)",
+				"old.go: 1 hunk, 21 lines (deleted file)",
+				"util.go: 1 hunk, 21 lines (only deletions)",
+				"main_test.go: 1 hunk, 21 lines (test)",
+			},
+			wantKept:    []string{hunks["main.go"][0], hunks["main.go"][1]},
+			wantDropped: []string{hunks["main_test.go"][0]},
+		},
+		{
+			name:   "largest code hunk next",
+			budget: total - tokensOf("go.sum", "api/api.pb.go", "vendor/lib/lib.go", "old.go", "util.go", "main_test.go", "main.go") + 7*note,
+			wantOmitted: []string{
+				"go.sum: 1 hunk, 21 lines (lockfile)",
+				"api/api.pb.go: 1 hunk, 21 lines (generated)",
+				"vendor/lib/lib.go: 1 hunk, 21 lines (vendored)",
+				"old.go: 1 hunk, 21 lines (deleted file)",
+				"util.go: 1 hunk, 21 lines (only deletions)",
+				"main_test.go: 1 hunk, 21 lines (test)",
+				"main.go: 1 hunk, 21 lines (largest hunks)",
+			},
+			wantKept:    []string{hunks["main.go"][1]},
+			wantDropped: []string{hunks["main.go"][0]},
+		},
+		{
+			name:   "whole files when the headers don't fit",
+			budget: 1,
+			wantOmitted: []string{
+				"go.sum: entire file, 24 lines (lockfile)",
+				"api/api.pb.go: entire file, 24 lines (generated)",
+				"vendor/lib/lib.go: entire file, 24 lines (vendored)",
+				"old.go: entire file, 25 lines (deleted file)",
+				// A whole file is described by its own kind, not by its hunks'
+				"util.go: entire file, 24 lines (largest hunks)",
+				"main_test.go: entire file, 24 lines (test)",
+				"main.go: entire file, 27 lines (largest hunks)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fitted, omitted := FitDiff(diff.String(), tt.budget)
+
+			var got []string
+			for _, o := range omitted {
+				got = append(got, o.String())
+			}
+			if !slices.Equal(got, tt.wantOmitted) {
+				t.Errorf("omitted:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.wantOmitted, "\n"))
+			}
+			if tt.budget > 0 && EstimateTokens(fitted) > tt.budget {
+				t.Errorf("fitted diff takes %d tokens, over the budget of %d", EstimateTokens(fitted), tt.budget)
+			}
+			for _, h := range tt.wantKept {
+				if !strings.Contains(fitted, h) {
+					t.Errorf("hunk left out:\n%s", h)
+				}
+			}
+			for _, h := range tt.wantDropped {
+				if strings.Contains(fitted, h) {
+					t.Errorf("hunk kept:\n%s", h)
+				}
+			}
+			// Files with omitted hunks keep their header and a note in their place
+			for _, o := range omitted {
+				header := fmt.Sprintf("diff --git a/%s b/%s\n", o.Path, o.Path)
+				if o.WholeFile == strings.Contains(fitted, header) {
+					t.Errorf("header of %s kept = %v, want %v", o.Path, !o.WholeFile, o.WholeFile)
+				}
+			}
+			if n := strings.Count(fitted, "[1 hunk omitted to fit the context window]\n"); tt.budget > 1 && n != len(omitted) {
+				t.Errorf("%d notes for %d omissions", n, len(omitted))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package budget
+
+import (
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// OllamaDefaultContext is the context window used for Ollama models the
+// registry doesn't know. Ollama's own default is smaller than most prompts.
+const OllamaDefaultContext = 8192
+
+// defaultModels are used by the providers when no model is configured
+var defaultModels = map[models.ProviderType]string{
+	models.ProviderOllama:    "qwen2.5-coder:14b-instruct-q8_0",
+	models.ProviderOpenAI:    "gpt-4",
+	models.ProviderAnthropic: "claude-3-sonnet-20240229",
+	models.ProviderDeepSeek:  "deepseek-chat",
+	models.ProviderGemini:    "gemini-1.5-pro",
+	models.ProviderBedrock:   "anthropic.claude-3-5-sonnet-20240620-v1:0",
+}
+
+// DefaultModel returns the model a provider uses when none is configured,
+// or "" for providers such as Azure OpenAI that have no default
+func DefaultModel(provider models.ProviderType) string {
+	return defaultModels[provider]
+}
+
+// defaultCapabilities are the limits of popular models, matched like
+// models.LookupModel does. Config files can override them per model.
+var defaultCapabilities = map[string]models.Capabilities{
+	// OpenAI
+	"gpt-4":         {ContextTokens: 8192, MaxOutputTokens: 4096},
+	"gpt-4-32k":     {ContextTokens: 32768, MaxOutputTokens: 4096},
+	"gpt-4-turbo":   {ContextTokens: 128000, MaxOutputTokens: 4096},
+	"gpt-4o":        {ContextTokens: 128000, MaxOutputTokens: 16384},
+	"gpt-4o-mini":   {ContextTokens: 128000, MaxOutputTokens: 16384},
+	"gpt-4.1":       {ContextTokens: 1047576, MaxOutputTokens: 32768},
+	"gpt-4.1-mini":  {ContextTokens: 1047576, MaxOutputTokens: 32768},
+	"gpt-3.5-turbo": {ContextTokens: 16385, MaxOutputTokens: 4096},
+	// Anthropic, also on Bedrock
+	"claude-3-opus":     {ContextTokens: 200000, MaxOutputTokens: 4096},
+	"claude-3-sonnet":   {ContextTokens: 200000, MaxOutputTokens: 4096},
+	"claude-3-haiku":    {ContextTokens: 200000, MaxOutputTokens: 4096},
+	"claude-3-5-sonnet": {ContextTokens: 200000, MaxOutputTokens: 8192},
+	"claude-3-5-haiku":  {ContextTokens: 200000, MaxOutputTokens: 8192},
+	"claude-3-7-sonnet": {ContextTokens: 200000, MaxOutputTokens: 64000},
+	// DeepSeek
+	"deepseek-chat":     {ContextTokens: 65536, MaxOutputTokens: 8192},
+	"deepseek-coder":    {ContextTokens: 65536, MaxOutputTokens: 8192},
+	"deepseek-reasoner": {ContextTokens: 65536, MaxOutputTokens: 8192},
+	// Gemini
+	"gemini-1.5-pro":   {ContextTokens: 2097152, MaxOutputTokens: 8192},
+	"gemini-1.5-flash": {ContextTokens: 1048576, MaxOutputTokens: 8192},
+	"gemini-2.0-flash": {ContextTokens: 1048576, MaxOutputTokens: 8192},
+	// Ollama
+	"devstral":          {ContextTokens: 131072},
+	"phi4":              {ContextTokens: 16384},
+	"qwen2.5-coder":     {ContextTokens: 32768},
+	"deepseek-coder-v2": {ContextTokens: 163840},
+	"llama3.1":          {ContextTokens: 131072},
+	"llama3.2":          {ContextTokens: 131072},
+	"mistral":           {ContextTokens: 32768},
+	"codellama":         {ContextTokens: 16384},
+	"gemma2":            {ContextTokens: 8192},
+}
+
+// Lookup returns the limits of a model, preferring the overrides to the
+// built-in registry. Limits that neither knows are zero, except the context
+// window of Ollama models, which gopr sets itself.
+func Lookup(provider models.ProviderType, model string, overrides map[string]models.Capabilities) models.Capabilities {
+	caps, _ := models.LookupModel(model, defaultCapabilities)
+	if override, ok := models.LookupModel(model, overrides); ok {
+		if override.ContextTokens > 0 {
+			caps.ContextTokens = override.ContextTokens
+		}
+		if override.MaxOutputTokens > 0 {
+			caps.MaxOutputTokens = override.MaxOutputTokens
+		}
+	}
+	if caps.ContextTokens == 0 && provider == models.ProviderOllama {
+		caps.ContextTokens = OllamaDefaultContext
+	}
+	return caps
+}
+
+// DefaultResponseTokens is the size of the response requested from a model
+// whose output limit is larger or unknown, which is plenty for a PR description
+const DefaultResponseTokens = 4000
+
+// ResponseTokens returns the size of the response to request from a model,
+// which is also what PromptTokens reserves for it
+func ResponseTokens(caps models.Capabilities) int {
+	if caps.MaxOutputTokens > 0 {
+		return min(DefaultResponseTokens, caps.MaxOutputTokens)
+	}
+	return DefaultResponseTokens
+}
+
+// PromptTokens returns how many tokens the prompt may use with room left for
+// the response, or zero when the context window is unknown
+func PromptTokens(caps models.Capabilities) int {
+	if caps.ContextTokens == 0 {
+		return 0
+	}
+	return max(caps.ContextTokens-ResponseTokens(caps), 0)
+}
@@ -0,0 +1,62 @@
+package budget
+
+import (
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestLookup(t *testing.T) {
+	overrides := map[string]models.Capabilities{
+		"my-model":          {ContextTokens: 32768, MaxOutputTokens: 2048},
+		"gpt-4o-2024-08-06": {ContextTokens: 64000},
+	}
+	tests := []struct {
+		name     string
+		provider models.ProviderType
+		model    string
+		want     models.Capabilities
+	}{
+		{name: "exact", provider: models.ProviderOpenAI, model: "gpt-4", want: models.Capabilities{ContextTokens: 8192, MaxOutputTokens: 4096}},
+		{name: "dated version", provider: models.ProviderOpenAI, model: "gpt-4-0613", want: models.Capabilities{ContextTokens: 8192, MaxOutputTokens: 4096}},
+		{name: "longest match wins", provider: models.ProviderOpenAI, model: "gpt-4o-mini-2024-07-18", want: models.Capabilities{ContextTokens: 128000, MaxOutputTokens: 16384}},
+		{name: "bedrock model ID", provider: models.ProviderBedrock, model: "anthropic.claude-3-5-sonnet-20240620-v1:0", want: models.Capabilities{ContextTokens: 200000, MaxOutputTokens: 8192}},
+		{name: "ollama tag", provider: models.ProviderOllama, model: "llama3.2:latest", want: models.Capabilities{ContextTokens: 131072}},
+		{name: "override of one limit keeps the other", provider: models.ProviderOpenAI, model: "gpt-4o-2024-08-06", want: models.Capabilities{ContextTokens: 64000, MaxOutputTokens: 16384}},
+		{name: "override matched by name", provider: models.ProviderOpenAICompatible, model: "my-model:latest", want: models.Capabilities{ContextTokens: 32768, MaxOutputTokens: 2048}},
+		{name: "unknown ollama model", provider: models.ProviderOllama, model: "starcoder2", want: models.Capabilities{ContextTokens: OllamaDefaultContext}},
+		{name: "unknown model", provider: models.ProviderOpenAICompatible, model: "local", want: models.Capabilities{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Lookup(tt.provider, tt.model, overrides); got != tt.want {
+				t.Errorf("Lookup(%s, %q) = %+v, want %+v", tt.provider, tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseAndPromptTokens(t *testing.T) {
+	tests := []struct {
+		name         string
+		caps         models.Capabilities
+		wantResponse int
+		wantPrompt   int
+	}{
+		{name: "large output limit", caps: models.Capabilities{ContextTokens: 128000, MaxOutputTokens: 16384}, wantResponse: DefaultResponseTokens, wantPrompt: 124000},
+		{name: "small output limit", caps: models.Capabilities{ContextTokens: 16384, MaxOutputTokens: 2048}, wantResponse: 2048, wantPrompt: 14336},
+		{name: "unknown output limit", caps: models.Capabilities{ContextTokens: 8192}, wantResponse: DefaultResponseTokens, wantPrompt: 4192},
+		{name: "unknown context window", caps: models.Capabilities{MaxOutputTokens: 4096}, wantResponse: DefaultResponseTokens, wantPrompt: 0},
+		{name: "window smaller than the response", caps: models.Capabilities{ContextTokens: 2048}, wantResponse: DefaultResponseTokens, wantPrompt: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResponseTokens(tt.caps); got != tt.wantResponse {
+				t.Errorf("ResponseTokens(%+v) = %d, want %d", tt.caps, got, tt.wantResponse)
+			}
+			if got := PromptTokens(tt.caps); got != tt.wantPrompt {
+				t.Errorf("PromptTokens(%+v) = %d, want %d", tt.caps, got, tt.wantPrompt)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package budget
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// charsPerToken is the average length of the letter and digit runs that
+// BPE tokenizers merge into a single token
+const charsPerToken = 4
+
+// EstimateTokens estimates how many tokens text takes without the model's
+// tokenizer. Runs of letters and digits count a token per four characters,
+// every symbol and non-ASCII character counts one, and so does each run of
+// whitespace longer than a single space. Code and diffs, which are dense in
+// symbols, come out within about a fifth of real tokenizers, erring high.
+func EstimateTokens(text string) int {
+	tokens := 0
+	word, space := 0, 0
+	flush := func() {
+		tokens += (word + charsPerToken - 1) / charsPerToken
+		if space > 1 {
+			tokens++
+		}
+		word, space = 0, 0
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		switch {
+		case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if space > 0 {
+				flush()
+			}
+			word++
+		case unicode.IsSpace(r):
+			if word > 0 {
+				flush()
+			}
+			space++
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
@@ -0,0 +1,26 @@
+package budget
+
+import "testing"
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{text: "", want: 0},
+		{text: "hello", want: 2},
+		{text: "hello world", want: 4},
+		// A run of whitespace longer than a single space is a token of its own
+		{text: "a  b", want: 3},
+		{text: "\n\n", want: 1},
+		// Every symbol counts one
+		{text: "x := 1", want: 4},
+		{text: "é", want: 1},
+		{text: "func main() {\n\tfmt.Println(\"hi\")\n}\n", want: 16},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// ResolveAPIKey fills in the API key of the resolved provider when no
+// api_key is set. The first of these that is configured is used:
+//
+//  1. api_key_cmd, from any layer
+//  2. api_key in the providers.<name> section or GOPR_<PROVIDER>_API_KEY
+//  3. api_key_cmd in the providers.<name> section
+//  4. the encrypted credential store, which asks for its passphrase
+//
+// Fallback providers get the same lookup, without step 1, deferred until
+// the fallback is used.
+func (r *Resolved) ResolveAPIKey() error {
+	if r.Config.APIKey != "" {
+		return nil
+	}
+
+	if r.Config.APIKeyCmd != "" {
+		key, err := RunKeyCommand(r.Config.APIKeyCmd)
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.Sources[KeyAPIKeyCmd], err)
+		}
+		r.Config.APIKey = key
+		r.Sources[KeyAPIKey] = KeyAPIKeyCmd + " from " + r.Sources[KeyAPIKeyCmd]
+		return nil
+	}
+
+	key, source, err := r.providerKey(r.Config.Provider)
+	if err != nil {
+		return err
+	}
+	if key != "" {
+		r.Config.APIKey = key
+		r.Sources[KeyAPIKey] = source
+	}
+	return nil
+}
+
+// providerKey looks up the key of a provider in its providers.<name>
+// section, the environment and the credential store, returning the key and
+// where it came from. An empty key means none is configured.
+func (r *Resolved) providerKey(provider models.ProviderType) (string, string, error) {
+	if key, ok := r.Config.APIKeys[provider]; ok {
+		return key, r.keySources[provider], nil
+	}
+
+	if s, ok := r.providerCmds[provider]; ok {
+		key, err := RunKeyCommand(s.value)
+		if err != nil {
+			return "", "", fmt.Errorf("%s: %w", s.source, err)
+		}
+		return key, KeyAPIKeyCmd + " from " + s.source, nil
+	}
+
+	if hasCredential(string(provider)) {
+		key, err := loadCredential(string(provider))
+		if err != nil {
+			return "", "", err
+		}
+		return key, "credential store", nil
+	}
+
+	return "", "", nil
+}
+
+// DescribeAPIKey returns the display value and source of the key that
+// ResolveAPIKey would use, without running api_key_cmd or opening the
+// credential store. Keys are masked and keys yet to be looked up are
+// described in parentheses.
+func (r *Resolved) DescribeAPIKey() (string, string) {
+	switch {
+	case r.Config.APIKey != "":
+		return MaskSecret(r.Config.APIKey), r.Sources[KeyAPIKey]
+	case r.Config.APIKeyCmd != "":
+		return "(from api_key_cmd)", r.Sources[KeyAPIKeyCmd]
+	}
+
+	provider := r.Config.Provider
+	if key, ok := r.Config.APIKeys[provider]; ok {
+		return MaskSecret(key), r.keySources[provider]
+	}
+	if s, ok := r.providerCmds[provider]; ok {
+		return "(from api_key_cmd)", s.source
+	}
+	if hasCredential(string(provider)) {
+		return "(encrypted)", "credential store"
+	}
+	return "", r.Sources[KeyAPIKey]
+}
+
+// RunKeyCommand runs a credential helper through the shell and returns its
+// trimmed stdout. Stdout is never included in errors as it may hold the key.
+func RunKeyCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("api_key_cmd %q failed: %v: %s", command, err, msg)
+		}
+		return "", fmt.Errorf("api_key_cmd %q failed: %v", command, err)
+	}
+
+	key := strings.TrimSpace(stdout.String())
+	if key == "" {
+		return "", fmt.Errorf("api_key_cmd %q printed no key", command)
+	}
+	return key, nil
+}
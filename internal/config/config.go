@@ -0,0 +1,571 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/git"
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// Keys of the configuration settings, in the order they are displayed
+const (
+	KeyProvider    = "provider"
+	KeyModel       = "model"
+	KeyAPIKey      = "api_key"
+	KeyAPIKeyCmd   = "api_key_cmd"
+	KeyBaseURL     = "base_url"
+	KeyHeaders     = "headers"
+	KeyAPIVersion  = "api_version"
+	KeyRegion      = "region"
+	KeyFallback    = "fallback"
+	KeyTemperature = "temperature"
+	KeyTemplate    = "template"
+	KeyStructured  = "structured"
+	// HTTP settings shared by every provider
+	KeyTimeout        = "timeout"
+	KeyConnectTimeout = "connect_timeout"
+	KeyCABundle       = "ca_bundle"
+)
+
+// Keys lists every setting that can be resolved
+var Keys = []string{KeyProvider, KeyModel, KeyAPIKey, KeyAPIKeyCmd, KeyBaseURL, KeyHeaders, KeyAPIVersion, KeyRegion, KeyTemperature, KeyTemplate, KeyStructured, KeyFallback, KeyTimeout, KeyConnectTimeout, KeyCABundle}
+
+// Default HTTP timeouts
+const (
+	DefaultTimeout        = 60 * time.Second
+	DefaultConnectTimeout = 10 * time.Second
+)
+
+// Keys that only select which settings apply
+const (
+	KeyProfile        = "profile"
+	KeyDefaultProfile = "default_profile"
+)
+
+// Keys of an override rule that select when it applies
+const (
+	KeyPaths    = "paths"
+	KeyBranches = "branches"
+)
+
+// Keys of the [provider.fake] section, which set what the fake provider answers
+const (
+	KeyFakeResponse = "response"
+	KeyFakeLatency  = "latency"
+	KeyFakeErrors   = "errors"
+	KeyFakeGeneric  = "generic"
+)
+
+// fakeKeys can also be set with GOPR_FAKE_<KEY>
+var fakeKeys = []string{KeyFakeResponse, KeyFakeLatency, KeyFakeErrors, KeyFakeGeneric}
+
+// Keys of a pricing section, in US dollars per million tokens
+const (
+	KeyInputPrice  = "input"
+	KeyOutputPrice = "output"
+)
+
+// Keys of a model section, which override the built-in model limits
+const (
+	KeyContextTokens   = "context_tokens"
+	KeyMaxOutputTokens = "max_output_tokens"
+)
+
+// Sources of a resolved setting that are not a config file
+const (
+	SourceDefault = "default"
+	SourceFlag    = "flag"
+)
+
+// envPrefix is prepended to the upper-cased key to form the environment variable name
+const envPrefix = "GOPR_"
+
+// Resolved holds the effective configuration and where each setting came from
+type Resolved struct {
+	Config  models.Config
+	Sources map[string]string
+	// Profile is the name of the selected profile, empty when none applies
+	Profile       string
+	ProfileSource string
+	// PricingSources records the config file section that priced each model
+	PricingSources map[string]string
+	// CapabilitySources records the config file section that set the limits of each model
+	CapabilitySources map[string]string
+
+	// providerCmds holds the api_key_cmd of each providers.<name> section
+	providerCmds map[models.ProviderType]setting
+	// providerSettings holds the model and base_url of each providers.<name>
+	// section, which apply when the provider is a fallback
+	providerSettings map[models.ProviderType]map[string]string
+	// keySources records where each per-provider key came from
+	keySources map[models.ProviderType]string
+	// fallbacks are the providers of the fallback chain, whose configs are
+	// rebuilt whenever a setting changes
+	fallbacks []models.ProviderType
+	// overrides are the override rules of every config file, in load order
+	overrides []*Override
+}
+
+// Load resolves the configuration from defaults, the config files found by
+// configFiles, the selected profile, GOPR_* environment variables
+// and the given flag values, each layer overriding the previous one. Flags
+// are keyed by setting name and should only contain flags that were
+// explicitly set.
+//
+// The profile is chosen by the profile flag, then GOPR_PROFILE, then the
+// default_profile key of the config files. Load never runs api_key_cmd or
+// opens the credential store; call ResolveAPIKey for that.
+func Load(flags map[string]string) (*Resolved, error) {
+	r := &Resolved{
+		Config: models.Config{
+			Provider:       models.ProviderOllama,
+			Temperature:    0.1,
+			APIKeys:        make(map[models.ProviderType]string),
+			Timeout:        DefaultTimeout,
+			ConnectTimeout: DefaultConnectTimeout,
+		},
+		Sources:           make(map[string]string),
+		PricingSources:    make(map[string]string),
+		CapabilitySources: make(map[string]string),
+		providerCmds:      make(map[models.ProviderType]setting),
+		providerSettings:  make(map[models.ProviderType]map[string]string),
+		keySources:        make(map[models.ProviderType]string),
+	}
+	for _, key := range Keys {
+		r.Sources[key] = SourceDefault
+	}
+
+	var files []*fileConfig
+	for _, filename := range configFiles() {
+		fc, err := parseFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fc)
+		r.overrides = append(r.overrides, fc.overrides...)
+	}
+
+	// Per-provider keys, later files overriding earlier ones. A key and a
+	// command replace each other, so the most specific one wins.
+	for _, fc := range files {
+		for name, settings := range fc.providers {
+			provider := models.ProviderType(name)
+			for _, s := range settings {
+				switch s.key {
+				case KeyAPIKey:
+					r.Config.APIKeys[provider] = s.value
+					r.keySources[provider] = s.source
+					delete(r.providerCmds, provider)
+				case KeyAPIKeyCmd:
+					r.providerCmds[provider] = s
+					delete(r.Config.APIKeys, provider)
+				case KeyModel, KeyBaseURL, KeyFakeResponse, KeyFakeLatency, KeyFakeErrors, KeyFakeGeneric:
+					if r.providerSettings[provider] == nil {
+						r.providerSettings[provider] = make(map[string]string)
+					}
+					r.providerSettings[provider][s.key] = s.value
+				}
+			}
+		}
+	}
+	if err := r.resolveFake(); err != nil {
+		return nil, err
+	}
+
+	// Prices per model, later files overriding earlier ones per key
+	for _, fc := range files {
+		for model, settings := range fc.pricing {
+			if r.Config.Pricing == nil {
+				r.Config.Pricing = make(map[string]models.Price)
+			}
+			price := r.Config.Pricing[model]
+			for _, s := range settings {
+				value, _ := strconv.ParseFloat(s.value, 64)
+				switch s.key {
+				case KeyInputPrice:
+					price.Input = value
+				case KeyOutputPrice:
+					price.Output = value
+				}
+				r.PricingSources[model] = s.source
+			}
+			r.Config.Pricing[model] = price
+		}
+	}
+
+	// Model limits, later files overriding earlier ones per key
+	for _, fc := range files {
+		for model, settings := range fc.models {
+			if r.Config.Capabilities == nil {
+				r.Config.Capabilities = make(map[string]models.Capabilities)
+			}
+			caps := r.Config.Capabilities[model]
+			for _, s := range settings {
+				value, _ := strconv.Atoi(s.value)
+				switch s.key {
+				case KeyContextTokens:
+					caps.ContextTokens = value
+				case KeyMaxOutputTokens:
+					caps.MaxOutputTokens = value
+				}
+				r.CapabilitySources[model] = s.source
+			}
+			r.Config.Capabilities[model] = caps
+		}
+	}
+
+	for provider, name := range providerKeysFromEnv() {
+		r.Config.APIKeys[provider] = os.Getenv(name)
+		r.keySources[provider] = "env " + name
+		delete(r.providerCmds, provider)
+	}
+
+	for _, fc := range files {
+		for _, s := range fc.global {
+			if s.key == KeyDefaultProfile {
+				r.Profile, r.ProfileSource = s.value, s.source
+				continue
+			}
+			if err := r.set(s.key, s.value, s.source); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if value := os.Getenv(EnvName(KeyProfile)); value != "" {
+		r.Profile, r.ProfileSource = value, "env "+EnvName(KeyProfile)
+	}
+	if value, ok := flags[KeyProfile]; ok && value != "" {
+		r.Profile, r.ProfileSource = value, SourceFlag
+	}
+
+	if r.Profile != "" {
+		found := false
+		for _, fc := range files {
+			settings, ok := fc.profiles[r.Profile]
+			if !ok {
+				continue
+			}
+			found = true
+			for _, s := range settings {
+				if err := r.set(s.key, s.value, s.source); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("profile %q from %s is not defined in any config file", r.Profile, r.ProfileSource)
+		}
+	}
+
+	for _, key := range Keys {
+		name := EnvName(key)
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			if err := r.set(key, value, "env "+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, key := range Keys {
+		if value, ok := flags[key]; ok {
+			if err := r.set(key, value, SourceFlag); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// providerKeysFromEnv finds the GOPR_<PROVIDER>_API_KEY variables, keyed by
+// provider and mapped to the variable name
+func providerKeysFromEnv() map[models.ProviderType]string {
+	keys := make(map[models.ProviderType]string)
+	suffix := "_" + strings.ToUpper(KeyAPIKey)
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if value == "" || name == EnvName(KeyAPIKey) {
+			continue
+		}
+		if !strings.HasPrefix(name, envPrefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		provider := strings.TrimSuffix(strings.TrimPrefix(name, envPrefix), suffix)
+		provider = strings.ReplaceAll(strings.ToLower(provider), "_", "-")
+		keys[models.ProviderType(provider)] = name
+	}
+	return keys
+}
+
+// EnvName returns the environment variable that sets the given key
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// Config file names, applied in this order within a directory. .gopr/config
+// is meant to be checked in and .goprrc.local to be git-ignored.
+var dirConfigFiles = []string{
+	filepath.Join(".gopr", "config"),
+	".goprrc",
+	".goprrc.local",
+}
+
+// configExtensions are tried after each config file name, the empty one
+// selecting the key=value format
+var configExtensions = []string{"", ".toml", ".yaml", ".yml", ".json"}
+
+// configFiles returns the config files to apply, from the most general to
+// the most specific: ~/.goprrc, then the files of every directory from the
+// root of the git repository down to the current directory. Each name is
+// also tried with the extension of every structured format. Outside a
+// repository only the current directory is searched.
+func configFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		for _, ext := range configExtensions {
+			configFile := name + ext
+			if !seen[configFile] && fileExists(configFile) {
+				seen[configFile] = true
+				files = append(files, configFile)
+			}
+		}
+	}
+
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		add(filepath.Join(homeDir, ".goprrc"))
+	}
+
+	for _, dir := range searchDirs() {
+		for _, name := range dirConfigFiles {
+			add(filepath.Join(dir, name))
+		}
+	}
+
+	return files
+}
+
+// searchDirs lists the directories from the repository root down to the current directory
+func searchDirs() []string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+	cwd, _ = filepath.EvalSymlinks(cwd)
+
+	root, err := git.TopLevel()
+	if err != nil {
+		return []string{cwd}
+	}
+	root, _ = filepath.EvalSymlinks(root)
+
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return []string{cwd}
+	}
+
+	dirs := []string{root}
+	if rel == "." {
+		return dirs
+	}
+	dir := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// set applies a single value to the configuration and records its source
+func (r *Resolved) set(key, value, source string) error {
+	if err := ValidateSetting(key, value); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	switch key {
+	case KeyProvider:
+		r.Config.Provider = models.ProviderType(value)
+	case KeyModel:
+		r.Config.Model = value
+	case KeyAPIKey:
+		r.Config.APIKey = value
+		r.Config.APIKeyCmd = ""
+		r.Sources[KeyAPIKeyCmd] = SourceDefault
+	case KeyAPIKeyCmd:
+		r.Config.APIKeyCmd = value
+		r.Config.APIKey = ""
+		r.Sources[KeyAPIKey] = SourceDefault
+	case KeyBaseURL:
+		r.Config.BaseURL = value
+	case KeyHeaders:
+		r.Config.Headers = parseHeaders(value)
+	case KeyAPIVersion:
+		r.Config.APIVersion = value
+	case KeyRegion:
+		r.Config.Region = value
+	case KeyTemperature:
+		r.Config.Temperature, _ = strconv.ParseFloat(value, 64)
+	case KeyTemplate:
+		r.Config.Template = value
+	case KeyStructured:
+		r.Config.Structured, _ = strconv.ParseBool(value)
+	case KeyTimeout:
+		r.Config.Timeout, _ = parseTimeout(value)
+	case KeyConnectTimeout:
+		r.Config.ConnectTimeout, _ = parseTimeout(value)
+	case KeyCABundle:
+		r.Config.CABundle = value
+	case KeyFallback:
+		r.fallbacks = nil
+		for _, name := range splitFallback(value) {
+			r.fallbacks = append(r.fallbacks, models.ProviderType(name))
+		}
+	default:
+		return fmt.Errorf("%s: unknown setting %q", source, key)
+	}
+
+	r.Sources[key] = source
+	r.Config.Fallbacks = nil
+	for _, provider := range r.fallbacks {
+		r.Config.Fallbacks = append(r.Config.Fallbacks, r.fallbackConfig(provider))
+	}
+	return nil
+}
+
+// Value returns the display value of a setting, with secrets masked
+func (r *Resolved) Value(key string) string {
+	switch key {
+	case KeyProvider:
+		return string(r.Config.Provider)
+	case KeyModel:
+		return r.Config.Model
+	case KeyAPIKey:
+		return MaskSecret(r.Config.APIKey)
+	case KeyAPIKeyCmd:
+		return r.Config.APIKeyCmd
+	case KeyBaseURL:
+		return r.Config.BaseURL
+	case KeyHeaders:
+		// Header values often carry tokens, so only the names are shown in full
+		names := make([]string, 0, len(r.Config.Headers))
+		for name, value := range r.Config.Headers {
+			names = append(names, name+": "+MaskSecret(value))
+		}
+		slices.Sort(names)
+		return strings.Join(names, ", ")
+	case KeyAPIVersion:
+		return r.Config.APIVersion
+	case KeyRegion:
+		return r.Config.Region
+	case KeyTemperature:
+		return strconv.FormatFloat(r.Config.Temperature, 'f', -1, 64)
+	case KeyTemplate:
+		return r.Config.Template
+	case KeyStructured:
+		return strconv.FormatBool(r.Config.Structured)
+	case KeyTimeout:
+		return r.Config.Timeout.String()
+	case KeyConnectTimeout:
+		return r.Config.ConnectTimeout.String()
+	case KeyCABundle:
+		return r.Config.CABundle
+	case KeyFallback:
+		names := make([]string, len(r.Config.Fallbacks))
+		for i, fallback := range r.Config.Fallbacks {
+			names[i] = string(fallback.Provider)
+		}
+		return strings.Join(names, ", ")
+	}
+	return ""
+}
+
+// resolveFake sets up the fake provider from its provider section, each
+// setting overridden by GOPR_FAKE_<KEY>
+func (r *Resolved) resolveFake() error {
+	settings := r.providerSettings[models.ProviderFake]
+	fake := models.FakeConfig{Model: settings[KeyModel]}
+	for _, key := range fakeKeys {
+		value := settings[key]
+		name := envPrefix + "FAKE_" + strings.ToUpper(key)
+		if env := os.Getenv(name); env != "" {
+			if err := ValidateSetting(key, env); err != nil {
+				return fmt.Errorf("env %s: %w", name, err)
+			}
+			value = env
+		}
+		if value == "" {
+			continue
+		}
+		switch key {
+		case KeyFakeResponse:
+			fake.Response = value
+		case KeyFakeLatency:
+			fake.Latency, _ = parseTimeout(value)
+		case KeyFakeErrors:
+			fake.Errors = splitList(value)
+		case KeyFakeGeneric:
+			fake.Generic, _ = strconv.Atoi(value)
+		}
+	}
+	r.Config.Fake = fake
+	return nil
+}
+
+// fallbackConfig returns the settings of a fallback provider: the resolved
+// settings shared by the chain, such as headers, api_version, region and
+// temperature, with the model and base_url of its providers.<name> section
+// and a key that is only looked up when the fallback is used
+func (r *Resolved) fallbackConfig(provider models.ProviderType) models.Config {
+	config := r.Config
+	config.Provider = provider
+	config.Model = r.providerSettings[provider][KeyModel]
+	config.BaseURL = r.providerSettings[provider][KeyBaseURL]
+	config.APIKey = ""
+	config.APIKeyCmd = ""
+	config.Fallbacks = nil
+	config.ResolveKey = func() (string, error) {
+		key, _, err := r.providerKey(provider)
+		return key, err
+	}
+	return config
+}
+
+// splitFallback splits a fallback chain, written as a comma-separated list
+// or as "ollama -> deepseek"
+func splitFallback(value string) []string {
+	return splitList(strings.ReplaceAll(value, "->", ","))
+}
+
+// parseHeaders reads a comma-separated list of "Name: value" headers
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, item := range splitList(value) {
+		name, val, _ := strings.Cut(item, ":")
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(val)
+	}
+	return headers
+}
+
+// MaskSecret hides all but the last four characters of a secret
+func MaskSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	if len(secret) <= 8 {
+		return strings.Repeat("*", len(secret))
+	}
+	return strings.Repeat("*", 8) + secret[len(secret)-4:]
+}
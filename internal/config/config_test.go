@@ -0,0 +1,119 @@
+package config
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// testDir makes an empty directory, outside any repository, the working,
+// home and config directory
+func testDir(t *testing.T) string {
+	t.Helper()
+	// Config files are found under the resolved working directory, which
+	// differs from the temporary one on macOS
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("USERPROFILE", dir)
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("AppData", dir)
+	return dir
+}
+
+func TestLoadFallbacks(t *testing.T) {
+	dir := testDir(t)
+	config := `provider = "azure-openai"
+api_key = "primary-key"
+base_url = "https://example.openai.azure.com"
+headers = ["X-Team: platform"]
+api_version = "2024-06-01"
+region = "us-east-1"
+temperature = 0.4
+fallback = "openai -> bedrock"
+
+[providers.openai]
+model = "gpt-4o-mini"
+base_url = "https://proxy.example.com/v1"
+`
+	if err := os.WriteFile(filepath.Join(dir, ".goprrc.toml"), []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Settings applied after the fallback still reach it
+	t.Setenv("GOPR_REGION", "eu-west-1")
+
+	r, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(r.Config.Fallbacks) != 2 {
+		t.Fatalf("got %d fallbacks, want 2", len(r.Config.Fallbacks))
+	}
+
+	openai, bedrock := r.Config.Fallbacks[0], r.Config.Fallbacks[1]
+	if openai.Provider != models.ProviderOpenAI || openai.Model != "gpt-4o-mini" || openai.BaseURL != "https://proxy.example.com/v1" {
+		t.Errorf("openai fallback = %s %q at %q, want the settings of its section", openai.Provider, openai.Model, openai.BaseURL)
+	}
+	if bedrock.Provider != models.ProviderBedrock || bedrock.Model != "" || bedrock.BaseURL != "" {
+		t.Errorf("bedrock fallback = %s %q at %q, want the provider defaults", bedrock.Provider, bedrock.Model, bedrock.BaseURL)
+	}
+	for _, fallback := range r.Config.Fallbacks {
+		if !maps.Equal(fallback.Headers, map[string]string{"X-Team": "platform"}) || fallback.APIVersion != "2024-06-01" || fallback.Region != "eu-west-1" || fallback.Temperature != 0.4 {
+			t.Errorf("%s fallback = %+v, want the shared settings", fallback.Provider, fallback)
+		}
+		if fallback.APIKey != "" || fallback.ResolveKey == nil || fallback.Fallbacks != nil {
+			t.Errorf("%s fallback has key %q, want its own key resolved when needed", fallback.Provider, fallback.APIKey)
+		}
+	}
+}
+
+func TestDescribeAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     string
+		env        map[string]string
+		stored     bool
+		wantValue  string
+		wantSource string
+	}{
+		{name: "api_key", config: "provider = openai\napi_key = sk-1234567890abcd\n", wantValue: "********abcd", wantSource: ".goprrc"},
+		{name: "api_key_cmd", config: "provider = openai\napi_key_cmd = exit 1\n", wantValue: "(from api_key_cmd)", wantSource: ".goprrc"},
+		{name: "provider section", config: "provider = openai\n[provider.openai]\napi_key = sk-1234567890abcd\n", wantValue: "********abcd", wantSource: ".goprrc [providers.openai]"},
+		{name: "provider section command", config: "provider = openai\n[provider.openai]\napi_key_cmd = exit 1\n", wantValue: "(from api_key_cmd)", wantSource: ".goprrc [providers.openai]"},
+		{name: "provider env", config: "provider = openai\n", env: map[string]string{"GOPR_OPENAI_API_KEY": "sk-1234567890abcd"}, wantValue: "********abcd", wantSource: "env GOPR_OPENAI_API_KEY"},
+		{name: "credential store", config: "provider = openai\n", stored: true, wantValue: "(encrypted)", wantSource: "credential store"},
+		{name: "none", config: "provider = openai\n", wantValue: "", wantSource: SourceDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := testDir(t)
+			if err := os.WriteFile(filepath.Join(dir, ".goprrc"), []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if tt.stored {
+				if err := StoreCredential("openai", "sk-1234567890abcd", "passphrase"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r, err := Load(nil)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			value, source := r.DescribeAPIKey()
+			if wantSource := strings.Replace(tt.wantSource, ".goprrc", filepath.Join(dir, ".goprrc"), 1); value != tt.wantValue || source != wantSource {
+				t.Errorf("DescribeAPIKey() = %q, %q, want %q, %q", value, source, tt.wantValue, wantSource)
+			}
+		})
+	}
+}
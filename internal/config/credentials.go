@@ -0,0 +1,252 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+)
+
+// Key derivation parameters of the credential store
+const (
+	credentialsVersion = 1
+	kdfIterations      = 600000
+	kdfKeyLength       = 32
+	saltLength         = 16
+)
+
+// PassphraseEnv holds the passphrase of the credential store for non-interactive use
+const PassphraseEnv = "GOPR_PASSPHRASE"
+
+// credentialFile is the on-disk form of the credential store. Each key is
+// sealed separately with AES-GCM, so the providers that have a key can be
+// listed without the passphrase.
+type credentialFile struct {
+	Version int               `json:"version"`
+	Salt    []byte            `json:"salt"`
+	Entries map[string]sealed `json:"entries"`
+}
+
+type sealed struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// CredentialsPath returns the location of the encrypted credential store
+func CredentialsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gopr", "credentials.json"), nil
+}
+
+// StoreCredential encrypts key with the passphrase and saves it for the provider,
+// replacing any existing key. All keys in the store share one passphrase.
+func StoreCredential(provider, key, passphrase string) error {
+	if err := validateProvider(provider); err != nil {
+		return err
+	}
+
+	path, err := CredentialsPath()
+	if err != nil {
+		return fmt.Errorf("failed to locate credential store: %w", err)
+	}
+
+	cf, err := readCredentials(path)
+	if err != nil {
+		return err
+	}
+	if cf == nil {
+		salt := make([]byte, saltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		cf = &credentialFile{Version: credentialsVersion, Salt: salt, Entries: make(map[string]sealed)}
+	}
+
+	gcm, err := cf.cipher(passphrase)
+	if err != nil {
+		return err
+	}
+	// Make sure the passphrase matches the one protecting the existing keys
+	for name, entry := range cf.Entries {
+		if _, err := gcm.Open(nil, entry.Nonce, entry.Data, []byte(name)); err != nil {
+			return fmt.Errorf("wrong passphrase for credential store %s", path)
+		}
+		break
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	cf.Entries[provider] = sealed{Nonce: nonce, Data: gcm.Seal(nil, nonce, []byte(key), []byte(provider))}
+
+	return writeCredentials(path, cf)
+}
+
+// RemoveCredential deletes the key of the provider from the store
+func RemoveCredential(provider string) error {
+	path, err := CredentialsPath()
+	if err != nil {
+		return fmt.Errorf("failed to locate credential store: %w", err)
+	}
+
+	cf, err := readCredentials(path)
+	if err != nil {
+		return err
+	}
+	if cf == nil || cf.Entries[provider].Data == nil {
+		return fmt.Errorf("no key stored for %s", provider)
+	}
+
+	delete(cf.Entries, provider)
+	return writeCredentials(path, cf)
+}
+
+// hasCredential reports whether the store holds a key for the provider
+func hasCredential(provider string) bool {
+	path, err := CredentialsPath()
+	if err != nil {
+		return false
+	}
+	cf, err := readCredentials(path)
+	return err == nil && cf != nil && cf.Entries[provider].Data != nil
+}
+
+// loadCredential decrypts the key of the provider, asking for the passphrase
+func loadCredential(provider string) (string, error) {
+	path, err := CredentialsPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate credential store: %w", err)
+	}
+	cf, err := readCredentials(path)
+	if err != nil {
+		return "", err
+	}
+	entry, ok := cf.Entries[provider]
+	if !ok {
+		return "", fmt.Errorf("no key stored for %s", provider)
+	}
+
+	passphrase := storePassphrase
+	if passphrase == "" {
+		passphrase, err = Passphrase("Passphrase for gopr credential store: ")
+		if err != nil {
+			return "", err
+		}
+	}
+	gcm, err := cf.cipher(passphrase)
+	if err != nil {
+		return "", err
+	}
+	key, err := gcm.Open(nil, entry.Nonce, entry.Data, []byte(provider))
+	if err != nil {
+		return "", fmt.Errorf("wrong passphrase for credential store %s", path)
+	}
+	storePassphrase = passphrase
+	return string(key), nil
+}
+
+// storePassphrase remembers the passphrase once it opened a key, so that
+// loading the key of a fallback provider doesn't ask for it again
+var storePassphrase string
+
+// cipher derives the AES-GCM cipher of the store from the passphrase
+func (cf *credentialFile) cipher(passphrase string) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errors.New("the credential store passphrase must not be empty")
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, cf.Salt, kdfIterations, kdfKeyLength)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// readCredentials loads the store, returning nil when it doesn't exist yet
+func readCredentials(path string) (*credentialFile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential store: %w", err)
+	}
+
+	var cf credentialFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store %s: %w", path, err)
+	}
+	if cf.Version != credentialsVersion {
+		return nil, fmt.Errorf("unsupported credential store version %d in %s", cf.Version, path)
+	}
+	if cf.Entries == nil {
+		cf.Entries = make(map[string]sealed)
+	}
+	return &cf, nil
+}
+
+func writeCredentials(path string, cf *credentialFile) error {
+	data, err := json.MarshalIndent(cf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode credential store: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create credential store directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	return nil
+}
+
+// Passphrase returns the credential store passphrase from GOPR_PASSPHRASE,
+// or asks for it on the terminal
+func Passphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	passphrase, err := ReadSecret(prompt)
+	if err != nil {
+		return "", fmt.Errorf("%w; set %s to provide the passphrase", err, PassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// ReadSecret asks for a value on the terminal without echoing it. The
+// controlling terminal is used when there is one, so that it works while
+// stdin is redirected, and stdin otherwise, which is how Windows consoles are
+// reached.
+func ReadSecret(prompt string) (string, error) {
+	in, out := os.Stdin, os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal available")
+	}
+
+	fmt.Fprint(out, prompt)
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(out)
+	if err != nil {
+		return "", fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// Sections of a config file
+const (
+	profilesSection  = "profiles"
+	providersSection = "providers"
+	overridesSection = "overrides"
+	pricingSection   = "pricing"
+	modelsSection    = "models"
+)
+
+// Keys accepted in each kind of section
+var (
+	globalKeys   = append([]string{KeyDefaultProfile}, Keys...)
+	profileKeys  = Keys
+	providerKeys = []string{KeyAPIKey, KeyAPIKeyCmd, KeyModel, KeyBaseURL}
+	overrideKeys = []string{KeyPaths, KeyBranches, KeyProvider, KeyModel, KeyTemplate, KeyTemperature}
+	pricingKeys  = []string{KeyInputPrice, KeyOutputPrice}
+	modelKeys    = []string{KeyContextTokens, KeyMaxOutputTokens}
+)
+
+// entry is a value read from a config file, addressed by its key path
+type entry struct {
+	path  []string
+	value string
+	line  int
+}
+
+// setting is a single validated value read from a config file
+type setting struct {
+	key    string
+	value  string
+	source string
+}
+
+// fileConfig holds the settings of a config file, grouped by section
+type fileConfig struct {
+	global    []setting
+	profiles  map[string][]setting
+	providers map[string][]setting
+	overrides []*Override
+	// pricing holds the price settings of each model
+	pricing map[string][]setting
+	// models holds the limits of each model
+	models map[string][]setting
+}
+
+// ConfigError reports an invalid config file, pointing at the offending line
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+// parseFile reads a config file, picking the format from its extension:
+// .toml, .yaml/.yml and .json, or the key=value format otherwise. The first
+// syntax or validation error is returned.
+func parseFile(filename string) (*fileConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var entries []entry
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		entries, err = parseTOML(data)
+	case ".yaml", ".yml":
+		entries, err = parseYAML(data)
+	case ".json":
+		entries, err = parseJSON(data)
+	default:
+		entries, err = parseINI(data)
+	}
+	if err != nil {
+		if ce, ok := err.(*ConfigError); ok {
+			ce.File = filename
+			return nil, ce
+		}
+		return nil, &ConfigError{File: filename, Msg: err.Error()}
+	}
+
+	return buildFileConfig(filename, entries)
+}
+
+// buildFileConfig groups entries by section and validates their keys and values
+func buildFileConfig(filename string, entries []entry) (*fileConfig, error) {
+	fc := &fileConfig{
+		profiles:  make(map[string][]setting),
+		providers: make(map[string][]setting),
+		pricing:   make(map[string][]setting),
+		models:    make(map[string][]setting),
+	}
+
+	for _, e := range entries {
+		fail := func(format string, args ...any) error {
+			return &ConfigError{File: filename, Line: e.line, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		s := setting{
+			key:    e.path[len(e.path)-1],
+			value:  e.value,
+			source: filename,
+		}
+		if (s.key == KeyTemplate || s.key == KeyCABundle || s.key == KeyFakeResponse) && s.value != "" && !filepath.IsAbs(s.value) {
+			// Templates, CA bundles and fixtures are relative to the config file that names them
+			s.value = filepath.Join(filepath.Dir(filename), s.value)
+		}
+		if path, ok := models.ProviderType(s.value).ExecPath(); ok && s.key == KeyProvider && strings.ContainsRune(path, filepath.Separator) && !filepath.IsAbs(path) {
+			// So are plugins given by path rather than looked up in PATH
+			s.value = models.ExecPrefix + filepath.Join(filepath.Dir(filename), path)
+		}
+
+		var allowed []string
+		switch {
+		case len(e.path) == 1:
+			allowed = globalKeys
+			fc.global = append(fc.global, s)
+		case len(e.path) == 3 && e.path[0] == profilesSection:
+			allowed = profileKeys
+			s.source = fmt.Sprintf("%s [%s.%s]", filename, profilesSection, e.path[1])
+			fc.profiles[e.path[1]] = append(fc.profiles[e.path[1]], s)
+		case len(e.path) == 3 && e.path[0] == providersSection:
+			if err := validateProvider(e.path[1]); err != nil {
+				return nil, fail("section %s.%s: %v", providersSection, e.path[1], err)
+			}
+			allowed = providerKeys
+			if models.ProviderType(e.path[1]) == models.ProviderFake {
+				allowed = slices.Concat(providerKeys, fakeKeys)
+			}
+			s.source = fmt.Sprintf("%s [%s.%s]", filename, providersSection, e.path[1])
+			fc.providers[e.path[1]] = append(fc.providers[e.path[1]], s)
+		case len(e.path) == 3 && e.path[0] == pricingSection:
+			allowed = pricingKeys
+			s.source = fmt.Sprintf("%s [%s.%s]", filename, pricingSection, e.path[1])
+			fc.pricing[e.path[1]] = append(fc.pricing[e.path[1]], s)
+		case len(e.path) == 3 && e.path[0] == modelsSection:
+			allowed = modelKeys
+			s.source = fmt.Sprintf("%s [%s.%s]", filename, modelsSection, e.path[1])
+			fc.models[e.path[1]] = append(fc.models[e.path[1]], s)
+		case len(e.path) == 3 && e.path[0] == overridesSection:
+			allowed = overrideKeys
+			s.source = fmt.Sprintf("%s [%s.%s]", filename, overridesSection, e.path[1])
+			rule := fc.override(e.path[1], s.source)
+			switch s.key {
+			case KeyPaths:
+				rule.Paths = append(rule.Paths, splitList(s.value)...)
+			case KeyBranches:
+				rule.Branches = append(rule.Branches, splitList(s.value)...)
+			default:
+				rule.settings = append(rule.settings, s)
+			}
+		default:
+			return nil, fail("unknown key %q", strings.Join(e.path, "."))
+		}
+
+		if !slices.Contains(allowed, s.key) {
+			return nil, fail("unknown key %q", strings.Join(e.path, "."))
+		}
+		if err := ValidateSetting(s.key, s.value); err != nil {
+			return nil, fail("%v", err)
+		}
+	}
+
+	for _, rule := range fc.overrides {
+		if len(rule.Paths) == 0 && len(rule.Branches) == 0 {
+			return nil, &ConfigError{File: filename, Msg: fmt.Sprintf("override %q needs paths or branches to match on", rule.Name)}
+		}
+	}
+
+	return fc, nil
+}
+
+// override returns the named override rule of the file, creating it if needed
+func (fc *fileConfig) override(name, source string) *Override {
+	for _, rule := range fc.overrides {
+		if rule.Name == name {
+			return rule
+		}
+	}
+	rule := &Override{Name: name, Source: source}
+	fc.overrides = append(fc.overrides, rule)
+	return rule
+}
+
+// splitList splits a comma-separated value, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseINI reads the key=value format. Lines after a [profile.<name>],
+// [provider.<name>], [override.<name>], [pricing.<model>] or [model.<name>]
+// header belong to that section.
+func parseINI(data []byte) ([]entry, error) {
+	var entries []entry
+	var section []string
+
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			header := strings.TrimSpace(line[1 : len(line)-1])
+			kind, name, ok := strings.Cut(header, ".")
+			if !ok || name == "" {
+				return nil, &ConfigError{Line: lineNo, Msg: fmt.Sprintf("invalid section [%s]", header)}
+			}
+			switch kind {
+			case "profile", profilesSection:
+				section = []string{profilesSection, name}
+			case "provider", providersSection:
+				section = []string{providersSection, name}
+			case "override", overridesSection:
+				section = []string{overridesSection, name}
+			case pricingSection:
+				section = []string{pricingSection, name}
+			case "model", modelsSection:
+				section = []string{modelsSection, name}
+			default:
+				return nil, &ConfigError{Line: lineNo, Msg: fmt.Sprintf("unknown section [%s]", header)}
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, &ConfigError{Line: lineNo, Msg: fmt.Sprintf("expected key=value, got %q", line)}
+		}
+
+		path := append(append([]string{}, section...), strings.TrimSpace(key))
+		entries = append(entries, entry{path: path, value: strings.TrimSpace(value), line: lineNo})
+	}
+
+	return entries, nil
+}
+
+// ValidateFile parses and validates a config file without applying it
+func ValidateFile(filename string) error {
+	_, err := parseFile(filename)
+	return err
+}
+
+// Files returns the config files that Load would apply, in order
+func Files() []string {
+	return configFiles()
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// flatten renders entries as "path=value (line)" for comparison
+func flatten(entries []entry) []string {
+	var lines []string
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s=%s (%d)", strings.Join(e.path, "."), e.value, e.line))
+	}
+	return lines
+}
+
+// writeConfig writes a config file named name in a temporary directory and returns its path
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+// configErrorTest is a config file that parseFile must reject at line with msg
+type configErrorTest struct {
+	name    string
+	content string
+	line    int
+	msg     string
+}
+
+// testConfigErrors checks that each file fails with a ConfigError naming the file and line
+func testConfigErrors(t *testing.T, filename string, tests []configErrorTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, filename, tt.content)
+			_, err := parseFile(path)
+			var ce *ConfigError
+			if !errors.As(err, &ce) {
+				t.Fatalf("parseFile = %v, want a ConfigError", err)
+			}
+			want := fmt.Sprintf("%s:%d: %s", path, tt.line, tt.msg)
+			if tt.line == 0 {
+				want = fmt.Sprintf("%s: %s", path, tt.msg)
+			}
+			if ce.File != path || ce.Line != tt.line || !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("error = %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestParseINI(t *testing.T) {
+	data := `# gopr config
+provider = openai
+temperature=0.2
+
+[profile.work]
+model = gpt-4o
+base_url = http://localhost:8080/v1?a=b
+
+[provider.openai]
+api_key = sk-test
+
+[pricing.gpt-4o]
+input = 2.5
+`
+	want := []string{
+		"provider=openai (2)",
+		"temperature=0.2 (3)",
+		"profiles.work.model=gpt-4o (6)",
+		"profiles.work.base_url=http://localhost:8080/v1?a=b (7)",
+		"providers.openai.api_key=sk-test (10)",
+		"pricing.gpt-4o.input=2.5 (13)",
+	}
+	entries, err := parseINI([]byte(data))
+	if err != nil {
+		t.Fatalf("parseINI: %v", err)
+	}
+	if got := flatten(entries); !slices.Equal(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestParseINIErrors(t *testing.T) {
+	testConfigErrors(t, "config", []configErrorTest{
+		{name: "section without a name", content: "provider = openai\n[work]\n", line: 2, msg: "invalid section [work]"},
+		{name: "unknown section", content: "[team.work]\n", line: 1, msg: "unknown section [team.work]"},
+		{name: "missing equals sign", content: "\n\nmodel gpt-4o\n", line: 3, msg: `expected key=value, got "model gpt-4o"`},
+	})
+}
+
+func TestParseFileValidation(t *testing.T) {
+	testConfigErrors(t, ".goprrc.toml", []configErrorTest{
+		{name: "unknown key", content: "provider = \"openai\"\ncolour = \"red\"\n", line: 2, msg: `unknown key "colour"`},
+		{name: "key of another section", content: "[providers.openai]\ntemperature = 0.2\n", line: 2, msg: `unknown key "providers.openai.temperature"`},
+		{name: "unknown section", content: "[teams.work]\nmodel = \"gpt-4o\"\n", line: 2, msg: `unknown key "teams.work.model"`},
+		{name: "bad provider", content: "[profiles.work]\nprovider = \"gpt\"\n", line: 2, msg: `unsupported provider "gpt" (expected one of: ollama, openai,`},
+		{name: "bad provider section", content: "[providers.gpt]\nmodel = \"gpt-4o\"\n", line: 2, msg: `section providers.gpt: unsupported provider "gpt"`},
+		{name: "temperature out of range", content: "[profiles.work]\ntemperature = 3\n", line: 2, msg: "invalid temperature 3: must be between 0 and 2"},
+		{name: "non-numeric context tokens", content: "[models.my-model]\ncontext_tokens = \"lots\"\n", line: 2, msg: `invalid context_tokens "lots": must be a positive number of tokens`},
+		{name: "fractional output tokens", content: "[models.my-model]\nmax_output_tokens = 1.5\n", line: 2, msg: `invalid max_output_tokens "1.5": must be a positive number of tokens`},
+		{name: "negative price", content: "[pricing.gpt-4o]\ninput = -1\n", line: 2, msg: `invalid input price "-1": must be a non-negative number`},
+		{name: "override without patterns", content: "[overrides.docs]\nmodel = \"gpt-4o-mini\"\n", msg: `override "docs" needs paths or branches to match on`},
+	})
+}
+
+func TestParseFile(t *testing.T) {
+	filename := writeConfig(t, ".goprrc.yaml", `provider: openai
+template: templates/pr.md
+profiles:
+  work:
+    provider: exec:plugins/gopr-llm
+providers:
+  openai:
+    api_key: sk-test
+overrides:
+  docs:
+    paths: [docs/*, "*.md"]
+    model: gpt-4o-mini
+`)
+	dir := filepath.Dir(filename)
+	if err := os.Mkdir(filepath.Join(dir, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "templates/pr.md"), []byte("## Summary\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fc, err := parseFile(filename)
+	if err != nil {
+		t.Fatalf("parseFile: %v", err)
+	}
+
+	if got, want := fc.global[1], (setting{key: KeyTemplate, value: filepath.Join(dir, "templates/pr.md"), source: filename}); got != want {
+		t.Errorf("template = %+v, want %+v, relative to the file", got, want)
+	}
+	if got, want := fc.profiles["work"][0], (setting{key: KeyProvider, value: "exec:" + filepath.Join(dir, "plugins/gopr-llm"), source: filename + " [profiles.work]"}); got != want {
+		t.Errorf("plugin = %+v, want %+v, relative to the file", got, want)
+	}
+	if got, want := fc.providers["openai"][0].source, filename+" [providers.openai]"; got != want {
+		t.Errorf("source = %q, want %q", got, want)
+	}
+	if len(fc.overrides) != 1 || !slices.Equal(fc.overrides[0].Paths, []string{"docs/*", "*.md"}) {
+		t.Errorf("overrides = %+v, want docs with two paths", fc.overrides)
+	}
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseJSON reads a JSON object of settings, nesting objects for sections.
+// Arrays of scalars are joined with commas.
+func parseJSON(data []byte) ([]entry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:min(int(offset), len(data))], []byte("\n")) + 1
+	}
+
+	var entries []entry
+	// walk reads the value following the current key path
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		line := lineAt(dec.InputOffset())
+
+		switch t := tok.(type) {
+		case json.Delim:
+			switch t {
+			case '{':
+				for dec.More() {
+					keyTok, err := dec.Token()
+					if err != nil {
+						return err
+					}
+					key, ok := keyTok.(string)
+					if !ok {
+						return &ConfigError{Line: lineAt(dec.InputOffset()), Msg: "expected object key"}
+					}
+					if err := walk(append(append([]string{}, path...), key)); err != nil {
+						return err
+					}
+				}
+				_, err := dec.Token()
+				return err
+			case '[':
+				if len(path) == 0 {
+					return &ConfigError{Line: line, Msg: "expected a JSON object"}
+				}
+				var items []string
+				for dec.More() {
+					itemTok, err := dec.Token()
+					if err != nil {
+						return err
+					}
+					item, err := jsonScalar(itemTok)
+					if err != nil {
+						return &ConfigError{Line: lineAt(dec.InputOffset()), Msg: err.Error()}
+					}
+					items = append(items, item)
+				}
+				if _, err := dec.Token(); err != nil {
+					return err
+				}
+				entries = append(entries, entry{path: path, value: strings.Join(items, ","), line: line})
+				return nil
+			}
+		}
+
+		if len(path) == 0 {
+			return &ConfigError{Line: line, Msg: "expected a JSON object"}
+		}
+		value, err := jsonScalar(tok)
+		if err != nil {
+			return &ConfigError{Line: line, Msg: err.Error()}
+		}
+		entries = append(entries, entry{path: path, value: value, line: line})
+		return nil
+	}
+
+	err := walk(nil)
+	if err == nil {
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = &ConfigError{Line: lineAt(dec.InputOffset()), Msg: "unexpected data after the JSON object"}
+		}
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, &ConfigError{Line: lineAt(syntaxErr.Offset), Msg: syntaxErr.Error()}
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			return nil, &ConfigError{Line: lineAt(int64(len(data))), Msg: "unexpected end of JSON input"}
+		}
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// jsonScalar converts a JSON string, number or boolean token to its string form
+func jsonScalar(tok json.Token) (string, error) {
+	switch v := tok.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case nil:
+		return "", fmt.Errorf("null values are not allowed")
+	}
+	return "", fmt.Errorf("nested objects and arrays are not allowed here")
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	data := `{
+  "provider": "openai",
+  "temperature": 0.2,
+  "structured": true,
+  "profiles": {
+    "work": {
+      "model": "caf\u00e9 \"gpt\"",
+      "headers": ["X-A: 1", "X-B: 2"]
+    }
+  },
+  "pricing": {"gpt-4o": {"input": 2.5, "output": 10}}
+}
+`
+	want := []string{
+		"provider=openai (2)",
+		"temperature=0.2 (3)",
+		"structured=true (4)",
+		`profiles.work.model=café "gpt" (7)`,
+		"profiles.work.headers=X-A: 1,X-B: 2 (8)",
+		"pricing.gpt-4o.input=2.5 (11)",
+		"pricing.gpt-4o.output=10 (11)",
+	}
+	entries, err := parseJSON([]byte(data))
+	if err != nil {
+		t.Fatalf("parseJSON: %v", err)
+	}
+	if got := flatten(entries); !slices.Equal(got, want) {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+}
+
+func TestParseJSONErrors(t *testing.T) {
+	testConfigErrors(t, ".goprrc.json", []configErrorTest{
+		{name: "trailing comma", content: "{\n  \"provider\": \"openai\",\n}\n", line: 2, msg: "invalid character ',' looking for beginning of value"},
+		{name: "null", content: "{\n  \"model\": null\n}\n", line: 2, msg: "null values are not allowed"},
+		{name: "object in an array", content: "{\n  \"fallback\": [\"ollama\",\n    {}]\n}\n", line: 3, msg: "nested objects and arrays are not allowed here"},
+		{name: "not an object", content: "\"openai\"\n", line: 1, msg: "expected a JSON object"},
+		{name: "array at the top", content: "[\"openai\"]\n", line: 1, msg: "expected a JSON object"},
+		{name: "data after the object", content: "{}\n{}\n", line: 2, msg: "unexpected data after the JSON object"},
+		{name: "truncated", content: "{\n  \"provider\": \"openai\"\n", line: 3, msg: "unexpected end of JSON input"},
+	})
+}
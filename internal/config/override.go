@@ -0,0 +1,135 @@
+package config
+
+import (
+	"path"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// Override is a rule that changes the provider, model, template or
+// temperature of runs whose branch or changed paths match its patterns
+type Override struct {
+	Name   string
+	Source string
+	// Paths are globs matched against the changed files, where ** matches
+	// any number of directories and a trailing slash matches a whole directory
+	Paths []string
+	// Branches are globs matched against the current branch name
+	Branches []string
+
+	settings []setting
+}
+
+// HasOverrides reports whether any config file defines override rules
+func (r *Resolved) HasOverrides() bool {
+	return len(r.overrides) > 0
+}
+
+// ApplyOverrides applies the last override rule that matches the branch and
+// changed files, like later lines win in .gitattributes. A rule with both
+// paths and branches must match both. Settings from the environment or flags
+// are kept. The applied rule is returned, or nil when none matches.
+func (r *Resolved) ApplyOverrides(branch string, changedFiles []string) (*Override, error) {
+	var matched *Override
+	for _, rule := range r.overrides {
+		if rule.matches(branch, changedFiles) {
+			matched = rule
+		}
+	}
+	if matched == nil {
+		return nil, nil
+	}
+
+	for _, s := range matched.settings {
+		if r.explicit(s.key) {
+			continue
+		}
+		if s.key == KeyProvider && models.ProviderType(s.value) != r.Config.Provider {
+			// Settings tied to the previous provider don't carry over, so
+			// the new provider's own key from its section is used instead
+			for _, key := range []string{KeyAPIKey, KeyAPIKeyCmd, KeyBaseURL} {
+				if !r.explicit(key) {
+					r.clear(key)
+				}
+			}
+		}
+		if err := r.set(s.key, s.value, "override "+matched.Name+" from "+s.source); err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// explicit reports whether the setting was given by an environment variable or a flag
+func (r *Resolved) explicit(key string) bool {
+	source := r.Sources[key]
+	return source == SourceFlag || strings.HasPrefix(source, "env ")
+}
+
+// clear resets a setting to its zero value
+func (r *Resolved) clear(key string) {
+	switch key {
+	case KeyAPIKey:
+		r.Config.APIKey = ""
+	case KeyAPIKeyCmd:
+		r.Config.APIKeyCmd = ""
+	case KeyBaseURL:
+		r.Config.BaseURL = ""
+	}
+	r.Sources[key] = SourceDefault
+}
+
+func (o *Override) matches(branch string, changedFiles []string) bool {
+	if len(o.Branches) > 0 && !matchAny(o.Branches, branch) {
+		return false
+	}
+	if len(o.Paths) > 0 {
+		for _, file := range changedFiles {
+			if matchAny(o.Paths, file) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob matches a slash-separated name against a pattern where each
+// segment follows path.Match and a ** segment matches zero or more segments
+func matchGlob(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := len(name); i >= 0; i-- {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
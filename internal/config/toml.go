@@ -0,0 +1,178 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bareKey matches the unquoted keys allowed by TOML
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseTOML reads the subset of TOML used by config files: tables, dotted
+// keys, strings, numbers, booleans and single-line arrays of strings.
+func parseTOML(data []byte) ([]entry, error) {
+	var entries []entry
+	var table []string
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		fail := func(format string, args ...any) error {
+			return &ConfigError{Line: lineNo, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		line := raw
+		if idx := indexOutsideQuotes(line, '#'); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if strings.HasPrefix(line, "[[") {
+				return nil, fail("arrays of tables are not supported")
+			}
+			if !strings.HasSuffix(line, "]") {
+				return nil, fail("unterminated table header %q", line)
+			}
+			keys, err := parseTOMLKey(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			table = keys
+			continue
+		}
+
+		idx := indexOutsideQuotes(line, '=')
+		if idx == -1 {
+			return nil, fail("expected key = value, got %q", line)
+		}
+		keys, err := parseTOMLKey(line[:idx])
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(line[idx+1:]))
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+
+		path := append(append([]string{}, table...), keys...)
+		entries = append(entries, entry{path: path, value: value, line: lineNo})
+	}
+
+	return entries, nil
+}
+
+// parseTOMLKey splits a possibly dotted and quoted key into its parts
+func parseTOMLKey(key string) ([]string, error) {
+	var parts []string
+	for _, part := range splitOutsideQuotes(key, '.') {
+		part = strings.TrimSpace(part)
+		switch {
+		case len(part) >= 2 && (part[0] == '"' || part[0] == '\''):
+			unquoted, err := unquote(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %q: %v", key, err)
+			}
+			parts = append(parts, unquoted)
+		case bareKey.MatchString(part):
+			parts = append(parts, part)
+		default:
+			return nil, fmt.Errorf("invalid key %q", strings.TrimSpace(key))
+		}
+	}
+	return parts, nil
+}
+
+// parseTOMLValue converts a TOML value to its string form. Arrays are joined with commas.
+func parseTOMLValue(value string) (string, error) {
+	if strings.HasPrefix(value, "[") {
+		if !strings.HasSuffix(value, "]") {
+			return "", fmt.Errorf("unterminated array %q", value)
+		}
+		inner := strings.TrimSpace(value[1 : len(value)-1])
+		if inner == "" {
+			return "", nil
+		}
+		var items []string
+		for _, item := range splitOutsideQuotes(inner, ',') {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				// Trailing commas are allowed
+				continue
+			}
+			parsed, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, parsed)
+		}
+		return strings.Join(items, ","), nil
+	}
+
+	switch {
+	case value == "":
+		return "", fmt.Errorf("missing value")
+	case value[0] == '"' || value[0] == '\'':
+		return unquote(value)
+	case value == "true" || value == "false":
+		return value, nil
+	}
+
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("invalid value %q (strings must be quoted)", value)
+	}
+	return strings.ReplaceAll(value, "_", ""), nil
+}
+
+// unquote removes the quotes around a double- or single-quoted string.
+// Escapes are only interpreted in double-quoted strings.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	if s[0] == '\'' {
+		return s[1 : len(s)-1], nil
+	}
+	unquoted, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return unquoted, nil
+}
+
+// indexOutsideQuotes returns the index of the first c that is not inside a quoted string, or -1
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s on every sep that is not inside a quoted string
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	for {
+		idx := indexOutsideQuotes(s, sep)
+		if idx == -1 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:idx])
+		s = s[idx+1:]
+	}
+}
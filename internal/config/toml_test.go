@@ -0,0 +1,87 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "tables",
+			data: `# gopr config
+provider = "openai"
+temperature = 0.2
+structured = true
+
+[profiles.work]
+model = "gpt-4o"  # the default model
+headers = ["X-A: 1", 'X-B: 2',]
+
+[pricing."gpt-4o"]
+input = 2.5
+output = 10_000
+`,
+			want: []string{
+				"provider=openai (2)",
+				"temperature=0.2 (3)",
+				"structured=true (4)",
+				"profiles.work.model=gpt-4o (7)",
+				"profiles.work.headers=X-A: 1,X-B: 2 (8)",
+				"pricing.gpt-4o.input=2.5 (11)",
+				"pricing.gpt-4o.output=10000 (12)",
+			},
+		},
+		{
+			name: "dotted keys",
+			data: "profiles.home.provider = \"ollama\"\n[models]\n\"llama3.2:latest\".context_tokens = 8192\n",
+			want: []string{
+				"profiles.home.provider=ollama (1)",
+				"models.llama3.2:latest.context_tokens=8192 (3)",
+			},
+		},
+		{
+			name: "escapes",
+			data: `api_key = "a\"b#c"
+template = 'C:\templates\#pr.md'
+model = "caf\u00e9\t"
+fallback = []
+`,
+			want: []string{
+				`api_key=a"b#c (1)`,
+				`template=C:\templates\#pr.md (2)`,
+				"model=café\t (3)",
+				"fallback= (4)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseTOML([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseTOML: %v", err)
+			}
+			if got := flatten(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	testConfigErrors(t, ".goprrc.toml", []configErrorTest{
+		{name: "unquoted string", content: "# gopr\nprovider = openai\n", line: 2, msg: `invalid value "openai" (strings must be quoted)`},
+		{name: "array of tables", content: "[[profiles]]\n", line: 1, msg: "arrays of tables are not supported"},
+		{name: "unterminated table", content: "\n[profiles.work\n", line: 2, msg: `unterminated table header "[profiles.work"`},
+		{name: "missing equals sign", content: "model \"gpt-4o\"\n", line: 1, msg: `expected key = value, got "model \"gpt-4o\""`},
+		{name: "missing value", content: "model =\n", line: 1, msg: "missing value"},
+		{name: "unterminated string", content: "model = \"gpt-4o\n", line: 1, msg: `unterminated string "gpt-4o`},
+		{name: "invalid escape", content: "model = \"gpt\\q\"\n", line: 1, msg: `invalid string "gpt\q"`},
+		{name: "invalid key", content: "api key = \"x\"\n", line: 1, msg: `invalid key "api key"`},
+		{name: "unterminated array", content: "[profiles.work]\nheaders = [\"X-A: 1\"\n", line: 2, msg: `unterminated array "[\"X-A: 1\""`},
+	})
+}
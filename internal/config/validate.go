@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// Allowed range of the temperature setting
+const (
+	minTemperature = 0.0
+	maxTemperature = 2.0
+)
+
+// apiVersionPattern matches Azure OpenAI API versions such as 2024-06-01 and 2024-10-01-preview
+var apiVersionPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(-preview)?$`)
+
+// regionPattern matches AWS region names such as us-east-1 and us-gov-west-1
+var regionPattern = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-\d+$`)
+
+// ValidateSetting checks a setting value against the config schema
+func ValidateSetting(key, value string) error {
+	switch key {
+	case KeyProvider:
+		return validateProvider(value)
+	case KeyTemperature:
+		temp, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid temperature %q: must be a number", value)
+		}
+		if temp < minTemperature || temp > maxTemperature {
+			return fmt.Errorf("invalid temperature %v: must be between %v and %v", temp, minTemperature, maxTemperature)
+		}
+	case KeyBaseURL:
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil {
+			return fmt.Errorf("invalid base_url %q: %v", value, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid base_url %q: must be an absolute http or https URL", value)
+		}
+	case KeyHeaders:
+		for _, item := range splitList(value) {
+			name, _, ok := strings.Cut(item, ":")
+			if !ok || !validHeaderName(strings.TrimSpace(name)) {
+				return fmt.Errorf("invalid header %q: expected \"Name: value\"", item)
+			}
+		}
+	case KeyAPIVersion:
+		if value != "" && !apiVersionPattern.MatchString(value) {
+			return fmt.Errorf("invalid api_version %q: expected a date such as 2024-06-01, optionally followed by -preview", value)
+		}
+	case KeyRegion:
+		if value != "" && !regionPattern.MatchString(value) {
+			return fmt.Errorf("invalid region %q: expected an AWS region such as us-east-1", value)
+		}
+	case KeyFallback:
+		for _, name := range splitFallback(value) {
+			if err := validateProvider(name); err != nil {
+				return fmt.Errorf("invalid fallback: %w", err)
+			}
+		}
+	case KeyTemplate:
+		if value == "" {
+			return nil
+		}
+		if _, err := os.Stat(value); err != nil {
+			return fmt.Errorf("invalid template: %v", err)
+		}
+	case KeyStructured:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid structured %q: must be true or false", value)
+		}
+	case KeyTimeout, KeyConnectTimeout:
+		if _, err := parseTimeout(value); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", key, value, err)
+		}
+	case KeyFakeResponse:
+		if _, err := os.Stat(value); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+	case KeyFakeLatency:
+		if _, err := parseTimeout(value); err != nil {
+			return fmt.Errorf("invalid latency %q: %v", value, err)
+		}
+	case KeyFakeErrors:
+		for _, name := range splitList(value) {
+			if !slices.Contains(models.FakeErrors, name) {
+				return fmt.Errorf("invalid error %q: expected one of %s", name, strings.Join(models.FakeErrors, ", "))
+			}
+		}
+	case KeyFakeGeneric:
+		if n, err := strconv.Atoi(value); err != nil || n < 0 {
+			return fmt.Errorf("invalid generic %q: must be a number of responses", value)
+		}
+	case KeyCABundle:
+		if value == "" {
+			return nil
+		}
+		if _, err := os.Stat(value); err != nil {
+			return fmt.Errorf("invalid ca_bundle: %v", err)
+		}
+	case KeyPaths, KeyBranches:
+		if len(splitList(value)) == 0 {
+			return fmt.Errorf("%s must list at least one pattern", key)
+		}
+		for _, pattern := range splitList(value) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid pattern %q in %s", pattern, key)
+			}
+		}
+	case KeyInputPrice, KeyOutputPrice:
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil || price < 0 {
+			return fmt.Errorf("invalid %s price %q: must be a non-negative number of US dollars per million tokens", key, value)
+		}
+	case KeyContextTokens, KeyMaxOutputTokens:
+		tokens, err := strconv.Atoi(value)
+		if err != nil || tokens <= 0 {
+			return fmt.Errorf("invalid %s %q: must be a positive number of tokens", key, value)
+		}
+	case KeyDefaultProfile:
+		if value == "" {
+			return fmt.Errorf("default_profile must not be empty")
+		}
+	}
+	return nil
+}
+
+// validateProvider checks that name is a supported provider
+func validateProvider(name string) error {
+	if models.ProviderType(name).Valid() {
+		return nil
+	}
+	names := make([]string, len(models.Providers))
+	for i, p := range models.Providers {
+		names[i] = string(p)
+	}
+	names = append(names, models.ExecPrefix+"<path>")
+	return fmt.Errorf("unsupported provider %q (expected one of: %s)", name, strings.Join(names, ", "))
+}
+
+// validHeaderName reports whether name is a valid HTTP header field name
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c >= 0x7f || c <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTimeout reads a timeout written as a duration such as 90s or 2m, or
+// as a number of seconds
+func parseTimeout(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, serr := strconv.ParseFloat(value, 64)
+		if serr != nil {
+			return 0, fmt.Errorf("expected a duration such as 90s or 2m")
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
@@ -0,0 +1,55 @@
+package config
+
+import "testing"
+
+func TestValidateSetting(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr string
+	}{
+		{key: KeyProvider, value: "anthropic"},
+		{key: KeyProvider, value: "exec:gopr-llm"},
+		{key: KeyProvider, value: "gpt", wantErr: `unsupported provider "gpt" (expected one of: ollama, openai, anthropic, deepseek, openai-compatible, azure-openai, gemini, bedrock, fake, exec:<path>)`},
+		{key: KeyTemperature, value: "0.7"},
+		{key: KeyTemperature, value: "warm", wantErr: `invalid temperature "warm": must be a number`},
+		{key: KeyTemperature, value: "2.5", wantErr: "invalid temperature 2.5: must be between 0 and 2"},
+		{key: KeyBaseURL, value: "https://llm.internal/v1"},
+		{key: KeyBaseURL, value: "llm.internal", wantErr: `invalid base_url "llm.internal": must be an absolute http or https URL`},
+		{key: KeyHeaders, value: "X-Team: platform, Authorization: Bearer x"},
+		{key: KeyHeaders, value: "X Team: platform", wantErr: `invalid header "X Team: platform": expected "Name: value"`},
+		{key: KeyAPIVersion, value: "2024-10-01-preview"},
+		{key: KeyAPIVersion, value: "2024-10", wantErr: `invalid api_version "2024-10": expected a date such as 2024-06-01, optionally followed by -preview`},
+		{key: KeyRegion, value: "us-gov-west-1"},
+		{key: KeyRegion, value: "US East", wantErr: `invalid region "US East": expected an AWS region such as us-east-1`},
+		{key: KeyFallback, value: "ollama, openai"},
+		{key: KeyFallback, value: "ollama, gpt", wantErr: `invalid fallback: unsupported provider "gpt" (expected one of: ollama, openai, anthropic, deepseek, openai-compatible, azure-openai, gemini, bedrock, fake, exec:<path>)`},
+		{key: KeyTemplate, value: "validate_test.go"},
+		{key: KeyStructured, value: "yes", wantErr: `invalid structured "yes": must be true or false`},
+		{key: KeyTimeout, value: "90"},
+		{key: KeyTimeout, value: "2m"},
+		{key: KeyTimeout, value: "0s", wantErr: `invalid timeout "0s": must be positive`},
+		{key: KeyConnectTimeout, value: "soon", wantErr: `invalid connect_timeout "soon": expected a duration such as 90s or 2m`},
+		{key: KeyFakeErrors, value: "rate_limit, server"},
+		{key: KeyFakeGeneric, value: "-1", wantErr: `invalid generic "-1": must be a number of responses`},
+		{key: KeyPaths, value: " , ", wantErr: "paths must list at least one pattern"},
+		{key: KeyBranches, value: "release/[", wantErr: `invalid pattern "release/[" in branches`},
+		{key: KeyInputPrice, value: "0.15"},
+		{key: KeyOutputPrice, value: "free", wantErr: `invalid output price "free": must be a non-negative number of US dollars per million tokens`},
+		{key: KeyContextTokens, value: "128000"},
+		{key: KeyContextTokens, value: "128k", wantErr: `invalid context_tokens "128k": must be a positive number of tokens`},
+		{key: KeyMaxOutputTokens, value: "0", wantErr: `invalid max_output_tokens "0": must be a positive number of tokens`},
+		{key: KeyDefaultProfile, value: "", wantErr: "default_profile must not be empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			err := ValidateSetting(tt.key, tt.value)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("ValidateSetting(%s, %q) = %v, want nil", tt.key, tt.value, err)
+			case tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr):
+				t.Errorf("ValidateSetting(%s, %q) = %v, want %q", tt.key, tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
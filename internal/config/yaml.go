@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseYAML reads the subset of YAML used by config files: nested block
+// mappings, scalars, sequences of scalars and comments.
+func parseYAML(data []byte) ([]entry, error) {
+	type level struct {
+		indent int
+		key    string
+	}
+
+	var entries []entry
+	var stack []level
+	// open is the last key without an inline value; it either starts a
+	// nested mapping, collects sequence items or ends up empty
+	var open *entry
+	var items []string
+
+	flush := func() {
+		if open != nil {
+			open.value = strings.Join(items, ",")
+			entries = append(entries, *open)
+			open, items = nil, nil
+		}
+	}
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		fail := func(format string, args ...any) error {
+			return &ConfigError{Line: lineNo, Msg: fmt.Sprintf(format, args...)}
+		}
+
+		line := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fail("tabs are not allowed for indentation")
+		}
+		indent := len(line) - len(content)
+
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			if open == nil {
+				return nil, fail("unexpected list item")
+			}
+			item, err := parseYAMLScalar(strings.TrimSpace(content[1:]))
+			if err != nil {
+				return nil, fail("%v", err)
+			}
+			items = append(items, item)
+			continue
+		}
+
+		if open != nil {
+			if len(stack) > 0 && indent > stack[len(stack)-1].indent && items == nil {
+				// The open key starts a nested mapping
+				open = nil
+			} else {
+				flush()
+			}
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		idx := indexOutsideQuotes(content, ':')
+		for idx != -1 && idx+1 < len(content) && content[idx+1] != ' ' {
+			// A colon not followed by a space is part of the key, as in URLs
+			next := indexOutsideQuotes(content[idx+1:], ':')
+			if next == -1 {
+				idx = -1
+			} else {
+				idx += next + 1
+			}
+		}
+		if idx == -1 {
+			return nil, fail("expected key: value, got %q", content)
+		}
+
+		key := strings.TrimSpace(content[:idx])
+		if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') {
+			unquoted, err := unquote(key)
+			if err != nil {
+				return nil, fail("invalid key %s", key)
+			}
+			key = unquoted
+		}
+		if key == "" {
+			return nil, fail("missing key")
+		}
+
+		path := make([]string, 0, len(stack)+1)
+		for _, l := range stack {
+			path = append(path, l.key)
+		}
+		path = append(path, key)
+
+		rest := strings.TrimSpace(content[idx+1:])
+		if rest == "" {
+			stack = append(stack, level{indent: indent, key: key})
+			open = &entry{path: path, line: lineNo}
+			continue
+		}
+
+		value, err := parseYAMLValue(rest)
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		entries = append(entries, entry{path: path, value: value, line: lineNo})
+	}
+	flush()
+
+	return entries, nil
+}
+
+// stripYAMLComment removes a trailing comment, which must start the line or follow whitespace
+func stripYAMLComment(line string) string {
+	offset := 0
+	for {
+		idx := indexOutsideQuotes(line[offset:], '#')
+		if idx == -1 {
+			return line
+		}
+		idx += offset
+		if idx == 0 || line[idx-1] == ' ' || line[idx-1] == '\t' {
+			return line[:idx]
+		}
+		offset = idx + 1
+	}
+}
+
+// parseYAMLValue converts an inline value, a scalar or a flow sequence, to its string form
+func parseYAMLValue(value string) (string, error) {
+	if !strings.HasPrefix(value, "[") {
+		return parseYAMLScalar(value)
+	}
+	if !strings.HasSuffix(value, "]") {
+		return "", fmt.Errorf("unterminated sequence %q", value)
+	}
+	inner := strings.TrimSpace(value[1 : len(value)-1])
+	if inner == "" {
+		return "", nil
+	}
+	var items []string
+	for _, item := range splitOutsideQuotes(inner, ',') {
+		parsed, err := parseYAMLScalar(strings.TrimSpace(item))
+		if err != nil {
+			return "", err
+		}
+		items = append(items, parsed)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// parseYAMLScalar unquotes a quoted scalar and rejects the YAML features config files don't use
+func parseYAMLScalar(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch value[0] {
+	case '"', '\'':
+		if value[0] == '\'' && len(value) >= 2 && value[len(value)-1] == '\'' {
+			// Single-quoted scalars escape a quote by doubling it
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+		}
+		return unquote(value)
+	case '{', '&', '*', '!', '|', '>':
+		return "", fmt.Errorf("unsupported YAML value %q", value)
+	}
+	if value == "~" || value == "null" {
+		return "", nil
+	}
+	return value, nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "nested mappings",
+			data: `---
+provider: openai  # comment
+base_url: http://localhost:8080/v1
+profiles:
+  work:
+    model: "gpt-4o"
+    headers:
+      - "X-A: 1"
+      - 'X-B: it''s'
+    fallback: [ollama, "deepseek"]
+pricing:
+  "gpt-4o":
+    input: 2.5
+api_key: ~
+`,
+			want: []string{
+				"provider=openai (2)",
+				"base_url=http://localhost:8080/v1 (3)",
+				"profiles.work.model=gpt-4o (6)",
+				"profiles.work.headers=X-A: 1,X-B: it's (7)",
+				"profiles.work.fallback=ollama,deepseek (10)",
+				"pricing.gpt-4o.input=2.5 (13)",
+				"api_key= (14)",
+			},
+		},
+		{
+			name: "quoting and comments",
+			data: `api_key: "a#b\"c"
+model: gpt#4  # the # only starts a comment after a space
+template: 'C:\templates\pr.md'
+fallback:
+region: us-east-1
+`,
+			want: []string{
+				`api_key=a#b"c (1)`,
+				"model=gpt#4 (2)",
+				`template=C:\templates\pr.md (3)`,
+				"fallback= (4)",
+				"region=us-east-1 (5)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseYAML([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseYAML: %v", err)
+			}
+			if got := flatten(entries); !slices.Equal(got, tt.want) {
+				t.Errorf("entries = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	testConfigErrors(t, ".goprrc.yaml", []configErrorTest{
+		{name: "tab indentation", content: "profiles:\n\twork:\n", line: 2, msg: "tabs are not allowed for indentation"},
+		{name: "list item without a key", content: "- openai\n", line: 1, msg: "unexpected list item"},
+		{name: "missing colon", content: "provider: openai\nmodel gpt-4o\n", line: 2, msg: `expected key: value, got "model gpt-4o"`},
+		{name: "flow mapping", content: "profiles: {work: {}}\n", line: 1, msg: `unsupported YAML value "{work: {}}"`},
+		{name: "anchor", content: "model: &default gpt-4o\n", line: 1, msg: `unsupported YAML value "&default gpt-4o"`},
+		{name: "unterminated sequence", content: "\nfallback: [ollama, openai\n", line: 2, msg: `unterminated sequence "[ollama, openai"`},
+		{name: "unterminated string", content: "model: \"gpt-4o\n", line: 1, msg: `unterminated string "gpt-4o`},
+		{name: "missing key", content: "provider: openai\n: gpt-4o\n", line: 2, msg: "missing key"},
+	})
+}
@@ -0,0 +1,84 @@
+package git
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// TopLevel returns the absolute path of the root of the repository containing the current directory
+func TopLevel() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CurrentBranch returns the name of the checked out branch
+func CurrentBranch() (string, error) {
+	cmd := exec.Command("git", "branch", "--show-current")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ChangedFiles lists the files changed on the current branch since it diverged from base
+func ChangedFiles(base string) ([]string, error) {
+	cmd := exec.Command("git", "diff", "--name-only", base+"...")
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// Version returns the output of git --version
+func Version() (string, error) {
+	cmd := exec.Command("git", "--version")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ResolveRef returns the commit a branch or other revision points to
+func ResolveRef(ref string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// RepoName names the repository after its origin remote, such as
+// "deleonn/gopr", or after the directory at its root when it has no origin
+func RepoName() (string, error) {
+	cmd := exec.Command("git", "remote", "get-url", "origin")
+	if output, err := cmd.Output(); err == nil {
+		remote := strings.TrimSuffix(strings.TrimSpace(string(output)), ".git")
+		// Both https://host/owner/repo and git@host:owner/repo end in owner/repo
+		parts := strings.FieldsFunc(remote, func(r rune) bool { return r == '/' || r == ':' })
+		if len(parts) >= 2 {
+			return parts[len(parts)-2] + "/" + parts[len(parts)-1], nil
+		}
+	}
+
+	root, err := TopLevel()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(root), nil
+}
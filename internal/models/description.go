@@ -0,0 +1,117 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Schema is a JSON schema that a structured response must match
+type Schema struct {
+	// Name identifies the schema, such as the tool name for Anthropic
+	Name        string
+	Description string
+	Schema      map[string]any
+}
+
+// StructuredGenerator is implemented by providers that can be made to answer
+// with JSON matching a schema. The JSON is returned as the content.
+type StructuredGenerator interface {
+	GenerateStructured(ctx context.Context, messages []Message, temperature float64, schema Schema) (Response, error)
+}
+
+// PRDescription is a PR description returned as structured output, one
+// field per section of the markdown gopr renders
+type PRDescription struct {
+	Summary    string   `json:"summary"`
+	Changes    []string `json:"changes"`
+	TestSteps  []string `json:"test_steps"`
+	Motivation string   `json:"motivation"`
+	Notes      []string `json:"notes"`
+}
+
+// DescriptionField describes a field of PRDescription, for the schema and the prompt
+type DescriptionField struct {
+	Name        string
+	Description string
+	// List is set for arrays of strings
+	List bool
+}
+
+// PRDescriptionFields lists the fields of PRDescription in the order of the sections
+var PRDescriptionFields = []DescriptionField{
+	{Name: "summary", Description: "Specific one or two sentence summary based on the actual changes"},
+	{Name: "changes", Description: "Each specific change in the diff, one per item", List: true},
+	{Name: "test_steps", Description: "Steps to test the changes, one per item", List: true},
+	{Name: "motivation", Description: "Why the change is made, based on the actual code changes"},
+	{Name: "notes", Description: "Breaking changes or important notes, empty when there are none", List: true},
+}
+
+// PRDescriptionSchema is the JSON schema of PRDescription. Every field is
+// required and no other is allowed, as OpenAI's strict mode demands.
+var PRDescriptionSchema = Schema{
+	Name:        "pr_description",
+	Description: "Write the pull request description",
+	Schema:      descriptionSchema(),
+}
+
+func descriptionSchema() map[string]any {
+	properties := make(map[string]any, len(PRDescriptionFields))
+	required := make([]string, len(PRDescriptionFields))
+	for i, field := range PRDescriptionFields {
+		if field.List {
+			properties[field.Name] = map[string]any{
+				"type":        "array",
+				"description": field.Description,
+				"items":       map[string]any{"type": "string"},
+			}
+		} else {
+			properties[field.Name] = map[string]any{"type": "string", "description": field.Description}
+		}
+		required[i] = field.Name
+	}
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// Markdown renders the description in the default PR description format
+func (d PRDescription) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# TL;DR\n%s\n\n", strings.TrimSpace(d.Summary))
+
+	b.WriteString("# What's changed?\n")
+	writeList(&b, d.Changes, false)
+
+	b.WriteString("\n# How to test?\n")
+	writeList(&b, d.TestSteps, true)
+
+	fmt.Fprintf(&b, "\n# Why make this change?\n%s\n\n", strings.TrimSpace(d.Motivation))
+
+	b.WriteString("# Breaking changes or important notes\n")
+	writeList(&b, d.Notes, false)
+	return b.String()
+}
+
+// writeList writes items as a bulleted or numbered list, or "- None" when there are none
+func writeList(b *strings.Builder, items []string, numbered bool) {
+	n := 0
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		n++
+		if numbered {
+			fmt.Fprintf(b, "%d. %s\n", n, item)
+		} else {
+			fmt.Fprintf(b, "- %s\n", item)
+		}
+	}
+	if n == 0 {
+		b.WriteString("- None\n")
+	}
+}
@@ -0,0 +1,129 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxErrorDetail bounds how much of a response body is shown in an error message
+const maxErrorDetail = 512
+
+// APIError is an error response from a provider. The more specific error
+// types below wrap it, so errors.As finds it behind any of them.
+type APIError struct {
+	// StatusCode is the HTTP status, zero for an error sent inside a stream
+	StatusCode int
+	// Code is the provider's error code or type, such as "rate_limit_exceeded"
+	Code    string
+	Message string
+	// Body is the raw response body
+	Body string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == 0 {
+		return "stream error" + e.detail()
+	}
+	return fmt.Sprintf("unexpected status code: %d%s", e.StatusCode, e.detail())
+}
+
+// detail describes the error with the provider's code and message, or the
+// start of the body when the response had neither
+func (e *APIError) detail() string {
+	message := e.Message
+	if message == "" {
+		message = strings.TrimSpace(e.Body)
+		if len(message) > maxErrorDetail {
+			message = message[:maxErrorDetail] + "..."
+		}
+	}
+	switch {
+	case e.Code != "" && message != "":
+		return ": " + e.Code + ": " + message
+	case e.Code != "":
+		return ": " + e.Code
+	case message != "":
+		return ": " + message
+	}
+	return ""
+}
+
+// describe formats the message of a specific error type
+func (e *APIError) describe(what string) string {
+	if e.StatusCode != 0 {
+		what += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+	return what + e.detail()
+}
+
+// ErrRateLimited is returned when the provider throttles requests
+type ErrRateLimited struct {
+	APIError
+	// RetryAfter is how long the provider asked to wait, zero when it didn't say
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	what := "rate limited"
+	if e.RetryAfter > 0 {
+		what += ", retry after " + e.RetryAfter.String()
+	}
+	return e.describe(what)
+}
+
+func (e *ErrRateLimited) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrAuth is returned when the provider rejects the credentials
+type ErrAuth struct {
+	APIError
+}
+
+func (e *ErrAuth) Error() string {
+	return e.describe("authentication failed")
+}
+
+func (e *ErrAuth) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrContextTooLong is returned when the prompt doesn't fit the model's context window
+type ErrContextTooLong struct {
+	APIError
+}
+
+func (e *ErrContextTooLong) Error() string {
+	return e.describe("prompt too long for the model")
+}
+
+func (e *ErrContextTooLong) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrContentFiltered is returned when the provider's content filter blocks the prompt or the response
+type ErrContentFiltered struct {
+	APIError
+}
+
+func (e *ErrContentFiltered) Error() string {
+	return e.describe("blocked by the content filter")
+}
+
+func (e *ErrContentFiltered) Unwrap() error {
+	return &e.APIError
+}
+
+// ErrServer is returned when the provider fails or is overloaded
+type ErrServer struct {
+	APIError
+}
+
+func (e *ErrServer) Error() string {
+	return e.describe("server error")
+}
+
+func (e *ErrServer) Unwrap() error {
+	return &e.APIError
+}
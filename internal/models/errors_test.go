@@ -0,0 +1,31 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestErrorMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "api error", err: &APIError{StatusCode: 404, Code: "not_found", Message: "no such model"}, want: "unexpected status code: 404: not_found: no such model"},
+		{name: "api error body", err: &APIError{StatusCode: 502, Body: " Bad Gateway\n"}, want: "unexpected status code: 502: Bad Gateway"},
+		{name: "stream error", err: &APIError{Code: "overloaded_error"}, want: "stream error: overloaded_error"},
+		{name: "rate limited", err: &ErrRateLimited{APIError: APIError{StatusCode: 429}, RetryAfter: 20 * time.Second}, want: "rate limited, retry after 20s (status 429)"},
+		{name: "auth", err: &ErrAuth{APIError: APIError{StatusCode: 401, Code: "invalid_api_key", Message: "Incorrect API key"}}, want: "authentication failed (status 401): invalid_api_key: Incorrect API key"},
+		{name: "content filtered", err: &ErrContentFiltered{APIError: APIError{StatusCode: 400, Code: "content_filter", Message: "the prompt was rejected"}}, want: "blocked by the content filter (status 400): content_filter: the prompt was rejected"},
+		{name: "content filtered in a stream", err: &ErrContentFiltered{APIError: APIError{Message: "the response was stopped"}}, want: "blocked by the content filter: the response was stopped"},
+		{name: "content filtered without a message", err: &ErrContentFiltered{APIError: APIError{StatusCode: 400}}, want: "blocked by the content filter (status 400)"},
+		{name: "server", err: &ErrServer{APIError: APIError{StatusCode: 529, Code: "overloaded_error"}}, want: "server error (status 529): overloaded_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,92 @@ package models
 
 import (
 	"context"
+	"strings"
+	"time"
 )
 
+// Role identifies who a message comes from
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single role-tagged message of a conversation
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// Usage counts the tokens of a generation, as reported by the provider
+type Usage struct {
+	// InputTokens counts every prompt token, cached ones included
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	// CacheReadTokens and CacheWriteTokens are the input tokens read from
+	// and written to the provider's prompt cache
+	CacheReadTokens  int `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
+}
+
+// Add returns the sum of two usages
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + other.InputTokens,
+		OutputTokens:     u.OutputTokens + other.OutputTokens,
+		CacheReadTokens:  u.CacheReadTokens + other.CacheReadTokens,
+		CacheWriteTokens: u.CacheWriteTokens + other.CacheWriteTokens,
+	}
+}
+
+// Response is the result of a generation
+type Response struct {
+	Content string
+	// Usage is zero when the provider didn't report it
+	Usage Usage
+	// StopReason is why the provider stopped generating, in its own terms,
+	// empty when it didn't say
+	StopReason string
+	// Truncated is set when the response was cut off at the output token limit
+	Truncated bool
+}
+
 // LLMProvider defines the interface for different LLM providers
 type LLMProvider interface {
-	GenerateResponse(ctx context.Context, prompt string, temperature float64) (string, error)
+	GenerateResponse(ctx context.Context, messages []Message, temperature float64) (Response, error)
+	// GenerateStream works like GenerateResponse but calls onToken with each
+	// piece of text as it arrives. It returns the full text at the end, along
+	// with the text received so far when the stream fails.
+	GenerateStream(ctx context.Context, messages []Message, temperature float64, onToken func(string)) (Response, error)
 	GetName() string
 	GetModel() string
 }
 
+// Diagnoser is implemented by providers that can check their endpoint and
+// credentials without generating anything
+type Diagnoser interface {
+	// Endpoint returns the URL generation requests are sent to
+	Endpoint() string
+	// Ping makes a cheap authenticated request, such as listing the models
+	Ping(ctx context.Context) error
+}
+
+// ModelLister is implemented by providers that can list the models they offer
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]ModelInfo, error)
+}
+
+// ModelInfo describes a model offered by a provider. Size and ContextTokens
+// are zero when the provider doesn't report them.
+type ModelInfo struct {
+	Name string
+	// Size is the size of the model's weights in bytes
+	Size          int64
+	ContextTokens int
+}
+
 // ProviderType represents the type of LLM provider
 type ProviderType string
 
@@ -19,40 +96,232 @@ const (
 	ProviderOpenAI    ProviderType = "openai"
 	ProviderAnthropic ProviderType = "anthropic"
 	ProviderDeepSeek  ProviderType = "deepseek"
+	// ProviderOpenAICompatible is any server speaking the OpenAI chat completions API
+	ProviderOpenAICompatible ProviderType = "openai-compatible"
+	ProviderAzureOpenAI      ProviderType = "azure-openai"
+	ProviderGemini           ProviderType = "gemini"
+	ProviderBedrock          ProviderType = "bedrock"
+	// ProviderFake answers without any LLM, for demos and tests
+	ProviderFake ProviderType = "fake"
 )
 
+// Providers lists the supported provider types
+var Providers = []ProviderType{ProviderOllama, ProviderOpenAI, ProviderAnthropic, ProviderDeepSeek, ProviderOpenAICompatible, ProviderAzureOpenAI, ProviderGemini, ProviderBedrock, ProviderFake}
+
+// ExecPrefix starts the provider type of an external plugin, followed by the path of its executable
+const ExecPrefix = "exec:"
+
+// ExecPath returns the plugin executable of an exec:<path> provider type
+func (p ProviderType) ExecPath() (string, bool) {
+	path, ok := strings.CutPrefix(string(p), ExecPrefix)
+	return path, ok && path != ""
+}
+
+// Valid reports whether p is a supported provider type or an exec:<path> plugin
+func (p ProviderType) Valid() bool {
+	if _, ok := p.ExecPath(); ok {
+		return true
+	}
+	for _, provider := range Providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// RequiresAPIKey reports whether the provider needs an API key. Bedrock
+// uses AWS credentials instead, plugins handle authentication themselves and
+// the fake provider needs none.
+func (p ProviderType) RequiresAPIKey() bool {
+	if _, ok := p.ExecPath(); ok {
+		return false
+	}
+	return p != ProviderOllama && p != ProviderOpenAICompatible && p != ProviderBedrock && p != ProviderFake
+}
+
 // Config holds the configuration for the application
 type Config struct {
 	Provider    ProviderType `json:"provider"`
 	Model       string       `json:"model"`
 	APIKey      string       `json:"api_key,omitempty"`
+	APIKeyCmd   string       `json:"api_key_cmd,omitempty"`
 	BaseURL     string       `json:"base_url,omitempty"`
 	Temperature float64      `json:"temperature"`
+	// Headers are extra HTTP headers sent with every request, such as an organization ID
+	Headers map[string]string `json:"headers,omitempty"`
+	// APIVersion is the api-version query parameter sent to Azure OpenAI
+	APIVersion string `json:"api_version,omitempty"`
+	// Region is the AWS region of Bedrock
+	Region string `json:"region,omitempty"`
+	// Template is the path of a file with the PR description format to ask for
+	Template string `json:"template,omitempty"`
+	// Structured asks for the description as JSON matching PRDescriptionSchema,
+	// which gopr renders into markdown itself
+	Structured bool `json:"structured,omitempty"`
+	// APIKeys holds keys per provider, used when APIKey is not set
+	APIKeys map[ProviderType]string `json:"api_keys,omitempty"`
+	// Fallbacks are tried in order when Provider can't answer
+	Fallbacks []Config `json:"fallbacks,omitempty"`
+	// ResolveKey looks up the API key when APIKey is empty. It is set on
+	// fallbacks, whose keys are only resolved when they are needed.
+	ResolveKey func() (string, error) `json:"-"`
+	// Pricing overrides the built-in prices, keyed by model
+	Pricing map[string]Price `json:"pricing,omitempty"`
+	// Capabilities overrides the built-in model limits, keyed by model
+	Capabilities map[string]Capabilities `json:"capabilities,omitempty"`
+	// Timeout bounds a request, or the wait for the first byte of a stream
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// ConnectTimeout bounds connecting to the provider, TLS handshake included
+	ConnectTimeout time.Duration `json:"connect_timeout,omitempty"`
+	// CABundle is a PEM file of certificate authorities trusted on top of the system ones
+	CABundle string `json:"ca_bundle,omitempty"`
+	// Fake configures the fake provider
+	Fake FakeConfig `json:"fake,omitempty"`
+}
+
+// Capabilities describes the limits of a model. Zero means unknown.
+type Capabilities struct {
+	// ContextTokens is the size of the context window, shared by the prompt and the response
+	ContextTokens int `json:"context_tokens"`
+	// MaxOutputTokens is the longest response the model can write
+	MaxOutputTokens int `json:"max_output_tokens"`
+}
+
+// LookupModel finds the entry of a model in a table keyed by model name. An
+// exact match wins, then the longest name the model contains, so that dated
+// versions such as gpt-4o-2024-08-06, Ollama tags such as phi4:latest and
+// Bedrock IDs such as anthropic.claude-3-5-sonnet-20240620-v1:0 find their family.
+func LookupModel[T any](model string, table map[string]T) (T, bool) {
+	if value, ok := table[model]; ok {
+		return value, true
+	}
+	var best string
+	for name := range table {
+		if strings.Contains(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		var zero T
+		return zero, false
+	}
+	return table[best], true
+}
+
+// Price is what a model costs, in US dollars per million tokens
+type Price struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
 }
 
 // OllamaConfig holds Ollama-specific configuration
 type OllamaConfig struct {
 	BaseURL string `json:"base_url"`
 	Model   string `json:"model"`
+	// ContextTokens is the largest context window requested from Ollama
+	ContextTokens int `json:"context_tokens"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
 }
 
 // OpenAIConfig holds OpenAI-specific configuration
 type OpenAIConfig struct {
-	APIKey  string `json:"api_key"`
-	Model   string `json:"model"`
-	BaseURL string `json:"base_url,omitempty"`
+	APIKey  string            `json:"api_key"`
+	Model   string            `json:"model"`
+	BaseURL string            `json:"base_url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+// OpenAICompatibleConfig holds the configuration of a server speaking the OpenAI chat completions API
+type OpenAICompatibleConfig struct {
+	APIKey  string            `json:"api_key,omitempty"`
+	Model   string            `json:"model"`
+	BaseURL string            `json:"base_url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
 }
 
 // AnthropicConfig holds Anthropic-specific configuration
 type AnthropicConfig struct {
 	APIKey string `json:"api_key"`
 	Model  string `json:"model"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
 }
 
-// DeepSeekConfig holds DeepSeek-specific configuration
-type DeepSeekConfig struct {
+// AzureOpenAIConfig holds Azure OpenAI-specific configuration
+type AzureOpenAIConfig struct {
+	APIKey string `json:"api_key"`
+	// Endpoint is the resource URL, such as https://my-resource.openai.azure.com
+	Endpoint string `json:"endpoint"`
+	// Deployment is the name given to the model when it was deployed
+	Deployment string            `json:"deployment"`
+	APIVersion string            `json:"api_version,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+// GeminiConfig holds Google Gemini-specific configuration
+type GeminiConfig struct {
 	APIKey  string `json:"api_key"`
 	Model   string `json:"model"`
 	BaseURL string `json:"base_url,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+// BedrockConfig holds Amazon Bedrock-specific configuration
+type BedrockConfig struct {
+	Model  string `json:"model"`
+	Region string `json:"region"`
+	// BaseURL replaces the regional endpoint, for VPC endpoints or a local stand-in
+	BaseURL         string `json:"base_url,omitempty"`
+	AccessKeyID     string `json:"-"`
+	SecretAccessKey string `json:"-"`
+	SessionToken    string `json:"-"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
+
+// ExecConfig holds the configuration of an external plugin
+type ExecConfig struct {
+	// Path is the plugin executable, looked up in PATH when it has no directory
+	Path    string `json:"path"`
+	Model   string `json:"model,omitempty"`
+	APIKey  string `json:"api_key,omitempty"`
+	BaseURL string `json:"base_url,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
 }
 
+// FakeErrors are the errors the fake provider can simulate
+var FakeErrors = []string{"rate_limit", "server", "auth", "context_too_long", "content_filter", "network", "invalid"}
+
+// FakeConfig holds the configuration of the fake provider
+type FakeConfig struct {
+	Model string `json:"model,omitempty"`
+	// Response is a fixture file with the response, rendered as a
+	// text/template over the data of the prompt. Empty selects a built-in one.
+	Response string `json:"response,omitempty"`
+	// Latency is how long each response takes
+	Latency time.Duration `json:"latency,omitempty"`
+	// Errors are returned by the first requests in turn, one of FakeErrors each
+	Errors []string `json:"errors,omitempty"`
+	// Generic is how many responses after the errors are too generic to pass validation
+	Generic int `json:"generic,omitempty"`
+}
+
+// DeepSeekConfig holds DeepSeek-specific configuration
+type DeepSeekConfig struct {
+	APIKey  string            `json:"api_key"`
+	Model   string            `json:"model"`
+	BaseURL string            `json:"base_url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// MaxTokens is the longest response requested, budget.DefaultResponseTokens when zero
+	MaxTokens int `json:"max_tokens,omitempty"`
+}
@@ -2,30 +2,37 @@ package service
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
+	"github.com/deleonn/gopr/internal/budget"
 	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
 )
 
 type AnthropicProvider struct {
-	apiKey string
-	model  string
+	apiKey    string
+	model     string
+	baseURL   string
+	maxTokens int
 }
 
 func NewAnthropicProvider(config models.AnthropicConfig) *AnthropicProvider {
 	model := config.Model
 
 	if model == "" {
-		model = "claude-3-sonnet-20240229"
+		model = budget.DefaultModel(models.ProviderAnthropic)
 	}
 
 	return &AnthropicProvider{
-		apiKey: config.APIKey,
-		model:  model,
+		apiKey:    config.APIKey,
+		model:     model,
+		baseURL:   "https://api.anthropic.com/v1",
+		maxTokens: cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
 	}
 }
 
@@ -37,60 +44,284 @@ func (a *AnthropicProvider) GetName() string {
 	return "Anthropic"
 }
 
-func (a *AnthropicProvider) GenerateResponse(ctx context.Context, prompt string, temperature float64) (string, error) {
+func (a *AnthropicProvider) Endpoint() string {
+	return a.baseURL + "/messages"
+}
+
+func (a *AnthropicProvider) Ping(ctx context.Context) error {
+	return pingEndpoint(ctx, a.baseURL+"/models", map[string]string{
+		"x-api-key":         a.apiKey,
+		"anthropic-version": "2023-06-01",
+	})
+}
+
+// requestBody builds a Messages API request. The system prompt holds the
+// instructions, which only change with the configuration, so it is marked
+// for the prompt cache and every run reads it back at a tenth of the price,
+// whatever the diff. Anthropic doesn't cache a prefix shorter than the
+// model's minimum, so a shorter system prompt, such as the built-in one
+// without a long template, isn't marked: it would never be read back.
+func (a *AnthropicProvider) requestBody(messages []models.Message, temperature float64) map[string]any {
+	system, conversation := splitSystemMessages(messages)
 	requestBody := map[string]any{
 		"model":       a.model,
-		"messages":    []map[string]string{{"role": "user", "content": prompt}},
+		"messages":    conversation,
 		"temperature": temperature,
-		"max_tokens":  4000,
+		"max_tokens":  a.maxTokens,
+	}
+	if system != "" {
+		block := map[string]any{"type": "text", "text": system}
+		if budget.EstimateTokens(system) >= anthropicMinCacheTokens(a.model) {
+			block["cache_control"] = map[string]string{"type": "ephemeral"}
+		}
+		requestBody["system"] = []map[string]any{block}
+	}
+	return requestBody
+}
+
+// anthropicMinCacheTokens is the shortest prefix the model caches
+func anthropicMinCacheTokens(model string) int {
+	if strings.Contains(model, "haiku") {
+		return 2048
+	}
+	return 1024
+}
+
+func (a *AnthropicProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	result, err := a.send(ctx, a.requestBody(messages, temperature))
+	if err != nil {
+		return models.Response{}, err
+	}
+
+	// Text may be split across blocks, such as around a citation, and
+	// thinking blocks aren't part of the answer
+	var text strings.Builder
+	hasText := false
+	for _, block := range result.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+			hasText = true
+		}
+	}
+
+	response := models.Response{Content: text.String(), Usage: result.Usage.usage()}
+	if err := anthropicStop(&response, result.StopReason); err != nil {
+		return models.Response{}, err
+	}
+	if !hasText {
+		return models.Response{}, fmt.Errorf("%w: no text", errInvalidResponse)
+	}
+	return response, nil
+}
+
+// GenerateStructured makes the model call a tool whose input schema is the
+// schema, and returns the input it wrote as the JSON content
+func (a *AnthropicProvider) GenerateStructured(ctx context.Context, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	requestBody := a.requestBody(messages, temperature)
+	requestBody["tools"] = []map[string]any{{
+		"name":         schema.Name,
+		"description":  schema.Description,
+		"input_schema": schema.Schema,
+	}}
+	requestBody["tool_choice"] = map[string]string{"type": "tool", "name": schema.Name}
+
+	result, err := a.send(ctx, requestBody)
+	if err != nil {
+		return models.Response{}, err
 	}
 
+	response := models.Response{Usage: result.Usage.usage()}
+	if err := anthropicStop(&response, result.StopReason); err != nil {
+		return models.Response{}, err
+	}
+	for _, block := range result.Content {
+		if block.Type == "tool_use" && block.Name == schema.Name {
+			response.Content = string(block.Input)
+			return response, nil
+		}
+	}
+	return models.Response{}, fmt.Errorf("%w: no %s tool call", errInvalidResponse, schema.Name)
+}
+
+// anthropicMessage is a Messages API response
+type anthropicMessage struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+		// Name and Input are the tool and arguments of a tool_use block
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content"`
+	StopReason string         `json:"stop_reason"`
+	Usage      anthropicUsage `json:"usage"`
+}
+
+// send posts a Messages API request and decodes the response
+func (a *AnthropicProvider) send(ctx context.Context, requestBody map[string]any) (anthropicMessage, error) {
 	body, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return anthropicMessage{}, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.anthropic.com/v1/messages", bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.Endpoint(), bytes.NewBuffer(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return anthropicMessage{}, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("x-api-key", a.apiKey)
 	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-	}
-	resp, err := client.Do(httpReq)
+	resp, err := transport.Client().Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return anthropicMessage{}, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return anthropicMessage{}, anthropicError(resp)
 	}
 
-	var result map[string]any
+	var result anthropicMessage
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return anthropicMessage{}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
 	}
+	return result, nil
+}
 
-	content, ok := result["content"].([]any)
-	if !ok || len(content) == 0 {
-		return "", fmt.Errorf("invalid response format: no content")
+func (a *AnthropicProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	requestBody := a.requestBody(messages, temperature)
+	requestBody["stream"] = true
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	firstContent, ok := content[0].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("invalid response format: invalid content")
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", a.Endpoint(), bytes.NewBuffer(body))
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to create request: %w", err)
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
 
-	text, ok := firstContent["text"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid response format: no text")
+	resp, err := transport.StreamClient().Do(httpReq)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Response{}, anthropicError(resp)
 	}
 
-	return text, nil
+	var text strings.Builder
+	var usage anthropicUsage
+	var stopReason string
+	err = readSSE(resp.Body, func(event, data string) error {
+		var chunk struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+				// StopReason is sent with message_delta
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Error anthropicErrorDetail `json:"error"`
+			// message_start reports the input and cache tokens and message_delta the output tokens
+			Message struct {
+				Usage anthropicUsage `json:"usage"`
+			} `json:"message"`
+			Usage anthropicUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+		}
+		switch chunk.Type {
+		case "message_start":
+			usage = chunk.Message.Usage
+		case "message_delta":
+			usage.OutputTokens = chunk.Usage.OutputTokens
+			stopReason = chunk.Delta.StopReason
+		case "message_stop":
+			return errStreamDone
+		case "error":
+			return streamError(chunk.Error.Type, chunk.Error.Message, []byte(data))
+		case "content_block_delta":
+			if chunk.Delta.Type == "text_delta" && chunk.Delta.Text != "" {
+				text.WriteString(chunk.Delta.Text)
+				onToken(chunk.Delta.Text)
+			}
+		}
+		return nil
+	})
+	response := models.Response{Content: text.String(), Usage: usage.usage()}
+	if err != nil {
+		return response, err
+	}
+	return response, anthropicStop(&response, stopReason)
 }
 
+// anthropicUsage is the token counts of an Anthropic response. The input
+// tokens exclude the ones read from or written to the prompt cache.
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u anthropicUsage) usage() models.Usage {
+	return models.Usage{
+		InputTokens:      u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		OutputTokens:     u.OutputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
+}
+
+// anthropicStop records why Anthropic stopped generating, returning an error
+// when the model refused to answer
+func anthropicStop(response *models.Response, stopReason string) error {
+	response.StopReason = stopReason
+	switch stopReason {
+	case "max_tokens":
+		response.Truncated = true
+	case "refusal":
+		return contentFiltered("Anthropic stopped the response (refusal)")
+	}
+	return nil
+}
+
+// anthropicErrorDetail is the error object of Anthropic error responses and stream events
+type anthropicErrorDetail struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicError describes an Anthropic error response, whose body is
+// {"type": "error", "error": {"type": "...", "message": "..."}}
+func anthropicError(resp *http.Response) error {
+	body := readErrorBody(resp)
+	var result struct {
+		Error anthropicErrorDetail `json:"error"`
+	}
+	json.Unmarshal(body, &result)
+	return providerError(resp, body, result.Error.Type, result.Error.Message)
+}
+
+// splitSystemMessages separates the system messages, which Anthropic takes as
+// a top-level field, from the rest of the conversation
+func splitSystemMessages(messages []models.Message) (string, []models.Message) {
+	var system []string
+	var conversation []models.Message
+	for _, m := range messages {
+		if m.Role == models.RoleSystem {
+			system = append(system, m.Content)
+		} else {
+			conversation = append(conversation, m)
+		}
+	}
+	return strings.Join(system, "\n\n"), conversation
+}
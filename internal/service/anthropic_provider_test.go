@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// anthropicRequest is the part of a Messages API request the cache depends on
+type anthropicRequest struct {
+	System []struct {
+		Text         string            `json:"text"`
+		CacheControl map[string]string `json:"cache_control"`
+	} `json:"system"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+func TestAnthropicCacheBreakpoint(t *testing.T) {
+	// About 1500 tokens, over the minimum of Sonnet and under the one of Haiku
+	longSystem := strings.Repeat("word ", 1500)
+	tests := []struct {
+		name       string
+		model      string
+		system     string
+		wantMarked bool
+	}{
+		{name: "long system prompt", model: "claude-3-5-sonnet-20241022", system: longSystem, wantMarked: true},
+		{name: "short system prompt", model: "claude-3-5-sonnet-20241022", system: "Describe the change."},
+		{name: "under the haiku minimum", model: "claude-3-5-haiku-20241022", system: longSystem},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewAnthropicProvider(models.AnthropicConfig{APIKey: "sk-ant-test", Model: tt.model})
+			messages := []models.Message{{Role: models.RoleSystem, Content: tt.system}, testMessages[1]}
+			data, err := json.Marshal(provider.requestBody(messages, 0.1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body anthropicRequest
+			if err := json.Unmarshal(data, &body); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(body.System) != 1 || body.System[0].Text != tt.system {
+				t.Fatalf("system = %+v, want one block with the system prompt", body.System)
+			}
+			if marked := body.System[0].CacheControl["type"] == "ephemeral"; marked != tt.wantMarked {
+				t.Errorf("system prompt marked for the cache = %v, want %v", marked, tt.wantMarked)
+			}
+			if strings.Count(string(data), "cache_control") > 1 {
+				t.Errorf("more than one cache breakpoint: %s", data)
+			}
+		})
+	}
+}
+
+func TestAnthropicCachedPrefixAcrossDiffs(t *testing.T) {
+	var requests []anthropicRequest
+	var systems [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body anthropicRequest
+		json.Unmarshal(data, &body)
+		var raw struct {
+			System json.RawMessage `json:"system"`
+		}
+		json.Unmarshal(data, &raw)
+		requests = append(requests, body)
+		systems = append(systems, raw.System)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"content":[{"type":"text","text":"Adds a line"}],"stop_reason":"end_turn","usage":{"input_tokens":20,"output_tokens":3}}`)
+	}))
+	defer server.Close()
+
+	provider := NewAnthropicProvider(models.AnthropicConfig{APIKey: "sk-ant-test", Model: "claude-3-5-sonnet-20241022"})
+	provider.baseURL = server.URL
+	system := models.Message{Role: models.RoleSystem, Content: strings.Repeat("word ", 1500)}
+	for _, diff := range []string{"+first change", "+second change"} {
+		messages := []models.Message{system, {Role: models.RoleUser, Content: "<git_diff>\n" + diff + "\n</git_diff>"}}
+		if _, err := provider.GenerateResponse(context.Background(), messages, 0.1); err != nil {
+			t.Fatalf("GenerateResponse: %v", err)
+		}
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("sent %d requests, want 2", len(requests))
+	}
+	if requests[0].System[0].CacheControl["type"] != "ephemeral" {
+		t.Fatal("system prompt not marked for the cache")
+	}
+	// The cached prefix ends at the breakpoint, so it is reused when the system blocks are identical
+	if !bytes.Equal(systems[0], systems[1]) {
+		t.Errorf("system blocks differ between diffs:\n%s\n%s", systems[0], systems[1])
+	}
+	if bytes.Equal(requests[0].Messages[0], requests[1].Messages[0]) {
+		t.Error("both requests sent the same diff")
+	}
+	for i, r := range requests {
+		for _, m := range r.Messages {
+			if bytes.Contains(m, []byte("cache_control")) {
+				t.Errorf("request %d marks a message, which holds the diff, for the cache: %s", i+1, m)
+			}
+		}
+	}
+}
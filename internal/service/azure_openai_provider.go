@@ -0,0 +1,123 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// defaultAzureAPIVersion is the GA version of the Azure OpenAI data plane API used when none is configured
+const defaultAzureAPIVersion = "2024-10-21"
+
+// azureJSONSchemaDate is the date of 2024-08-01-preview, the first API
+// version that accepts a response_format of json_schema
+var azureJSONSchemaDate = time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC)
+
+// AzureOpenAIProvider talks to an Azure OpenAI deployment. Requests go to the
+// deployment's URL with an api-version query parameter and authenticate with
+// an api-key header.
+type AzureOpenAIProvider struct {
+	*OpenAICompatibleProvider
+}
+
+func NewAzureOpenAIProvider(config models.AzureOpenAIConfig) *AzureOpenAIProvider {
+	apiVersion := config.APIVersion
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+
+	endpoint := strings.TrimRight(config.Endpoint, "/")
+	provider := NewOpenAICompatibleProvider(models.OpenAICompatibleConfig{
+		APIKey:    config.APIKey,
+		Model:     config.Deployment,
+		BaseURL:   endpoint + "/openai/deployments/" + url.PathEscape(config.Deployment),
+		Headers:   config.Headers,
+		MaxTokens: config.MaxTokens,
+	})
+	provider.name = "Azure OpenAI"
+	provider.query = url.Values{"api-version": {apiVersion}}
+	provider.pingURL = endpoint + "/openai/models"
+	provider.deployments = true
+	provider.authHeader = "api-key"
+	provider.jsonObjectOnly = !azureJSONSchema(apiVersion)
+	provider.mapError = azureError
+
+	return &AzureOpenAIProvider{provider}
+}
+
+// azureJSONSchema reports whether an API version accepts json_schema. Versions
+// start with their date, and a version that doesn't is assumed to be newer.
+func azureJSONSchema(apiVersion string) bool {
+	date, err := time.Parse(time.DateOnly, apiVersion[:min(len(apiVersion), len(time.DateOnly))])
+	if err != nil {
+		return true
+	}
+	return !date.Before(azureJSONSchemaDate)
+}
+
+// azureError describes the Azure OpenAI error responses that need more than
+// the default description. Prompts rejected by the content filter name the
+// categories that triggered it.
+func azureError(resp *http.Response, body []byte) error {
+	var result struct {
+		Error struct {
+			Code       string `json:"code"`
+			Message    string `json:"message"`
+			InnerError struct {
+				Code                string                     `json:"code"`
+				ContentFilterResult map[string]json.RawMessage `json:"content_filter_result"`
+			} `json:"innererror"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || result.Error.Code == "" {
+		return nil
+	}
+
+	if result.Error.Code == "content_filter" || result.Error.InnerError.Code == "ResponsibleAIPolicyViolation" {
+		message := "Azure rejected the prompt, which includes the diff and commit messages"
+		if categories := filteredCategories(result.Error.InnerError.ContentFilterResult); len(categories) > 0 {
+			message += ", for " + strings.Join(categories, ", ")
+		}
+		return &models.ErrContentFiltered{APIError: models.APIError{
+			StatusCode: resp.StatusCode,
+			Code:       result.Error.Code,
+			Message:    message,
+			Body:       string(body),
+		}}
+	}
+
+	switch result.Error.Code {
+	case "DeploymentNotFound":
+		return providerError(resp, body, result.Error.Code, "deployment not found, check that model names an Azure deployment: "+result.Error.Message)
+	case "401":
+		return providerError(resp, body, "", "access denied, check the API key of the Azure resource: "+result.Error.Message)
+	}
+	return nil
+}
+
+// filteredCategories lists the content filter categories that blocked a request, with their severity
+func filteredCategories(results map[string]json.RawMessage) []string {
+	var categories []string
+	for name, raw := range results {
+		var result struct {
+			Filtered bool   `json:"filtered"`
+			Severity string `json:"severity"`
+			Detected bool   `json:"detected"`
+		}
+		if json.Unmarshal(raw, &result) != nil || !result.Filtered {
+			continue
+		}
+		category := strings.ReplaceAll(name, "_", " ")
+		if result.Severity != "" {
+			category += " (" + result.Severity + " severity)"
+		}
+		categories = append(categories, category)
+	}
+	slices.Sort(categories)
+	return categories
+}
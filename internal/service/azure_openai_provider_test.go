@@ -0,0 +1,59 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestAzureOpenAIResponseFormat(t *testing.T) {
+	tests := []struct {
+		apiVersion string
+		want       string
+	}{
+		{apiVersion: "", want: "json_schema"},
+		{apiVersion: "2024-08-01-preview", want: "json_schema"},
+		{apiVersion: "2024-08-01", want: "json_schema"},
+		{apiVersion: "2024-09-01-preview", want: "json_schema"},
+		{apiVersion: "2025-04-01-preview", want: "json_schema"},
+		{apiVersion: "preview", want: "json_schema"},
+		{apiVersion: "2024-07-01-preview", want: "json_object"},
+		{apiVersion: "2024-06-01", want: "json_object"},
+		{apiVersion: "2024-02-15-preview", want: "json_object"},
+	}
+	for _, tt := range tests {
+		t.Run(cmp.Or(tt.apiVersion, "default"), func(t *testing.T) {
+			var query url.Values
+			var body struct {
+				ResponseFormat struct {
+					Type string `json:"type"`
+				} `json:"response_format"`
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				json.NewDecoder(r.Body).Decode(&body)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"choices":[{"message":{"content":"{}"},"finish_reason":"stop"}]}`)
+			}))
+			defer server.Close()
+
+			provider := NewAzureOpenAIProvider(models.AzureOpenAIConfig{APIKey: "azure-test", Endpoint: server.URL, Deployment: "gpt-4o", APIVersion: tt.apiVersion})
+			if _, err := provider.GenerateStructured(context.Background(), testMessages, 0.1, models.PRDescriptionSchema); err != nil {
+				t.Fatalf("GenerateStructured: %v", err)
+			}
+			if want := cmp.Or(tt.apiVersion, defaultAzureAPIVersion); query.Get("api-version") != want {
+				t.Errorf("api-version = %q, want %q", query.Get("api-version"), want)
+			}
+			if body.ResponseFormat.Type != tt.want {
+				t.Errorf("response_format type = %q, want %q", body.ResponseFormat.Type, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/aws"
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+// bedrockSigningName is the SigV4 service name of both the Bedrock runtime and control plane
+const bedrockSigningName = "bedrock"
+
+// BedrockProvider calls models on Amazon Bedrock through the Converse API,
+// signing each request with SigV4
+type BedrockProvider struct {
+	model   string
+	baseURL string
+	// pingURL is the control plane URL that Ping checks
+	pingURL   string
+	signer    *aws.Signer
+	maxTokens int
+}
+
+func NewBedrockProvider(config models.BedrockConfig) *BedrockProvider {
+	model := config.Model
+
+	if model == "" {
+		model = budget.DefaultModel(models.ProviderBedrock)
+	}
+
+	// A custom base URL, such as a VPC endpoint or a local stand-in, serves both APIs
+	baseURL := strings.TrimRight(config.BaseURL, "/")
+	pingURL := baseURL + "/foundation-models"
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", config.Region)
+		pingURL = fmt.Sprintf("https://bedrock.%s.amazonaws.com/foundation-models", config.Region)
+	}
+
+	return &BedrockProvider{
+		model:     model,
+		baseURL:   baseURL,
+		pingURL:   pingURL,
+		maxTokens: cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
+		signer: &aws.Signer{
+			Credentials: aws.Credentials{
+				AccessKeyID:     config.AccessKeyID,
+				SecretAccessKey: config.SecretAccessKey,
+				SessionToken:    config.SessionToken,
+			},
+			Region:  config.Region,
+			Service: bedrockSigningName,
+		},
+	}
+}
+
+func (b *BedrockProvider) GetModel() string {
+	return b.model
+}
+
+func (b *BedrockProvider) GetName() string {
+	return "Bedrock"
+}
+
+func (b *BedrockProvider) Endpoint() string {
+	return b.modelURL() + "/converse"
+}
+
+// Ping checks the credentials and region by listing the foundation models
+func (b *BedrockProvider) Ping(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", b.pingURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	b.signer.Sign(httpReq, nil)
+
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return bedrockError(resp)
+	}
+	return nil
+}
+
+// modelURL is the runtime URL of the configured model. Colons in model IDs
+// are escaped like the AWS SDKs do.
+func (b *BedrockProvider) modelURL() string {
+	return b.baseURL + "/model/" + strings.ReplaceAll(url.PathEscape(b.model), ":", "%3A")
+}
+
+func (b *BedrockProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	resp, err := b.send(ctx, b.Endpoint(), messages, temperature, transport.Client())
+	if err != nil {
+		return models.Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Output struct {
+			Message struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"message"`
+		} `json:"output"`
+		StopReason string       `json:"stopReason"`
+		Usage      bedrockUsage `json:"usage"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return models.Response{}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+	}
+	if result.StopReason == "guardrail_intervened" || result.StopReason == "content_filtered" {
+		return models.Response{}, contentFiltered("Bedrock stopped the response (" + result.StopReason + ")")
+	}
+
+	var text strings.Builder
+	for _, block := range result.Output.Message.Content {
+		text.WriteString(block.Text)
+	}
+	if text.Len() == 0 {
+		return models.Response{}, fmt.Errorf("%w: no text", errInvalidResponse)
+	}
+	return models.Response{Content: text.String(), Usage: result.Usage.usage()}, nil
+}
+
+func (b *BedrockProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	resp, err := b.send(ctx, b.modelURL()+"/converse-stream", messages, temperature, transport.StreamClient())
+	if err != nil {
+		return models.Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage models.Usage
+	// stopped is set by messageStop, which is followed by the metadata event
+	stopped := false
+	stream := aws.NewEventStreamReader(resp.Body)
+	for {
+		message, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			if !stopped {
+				return models.Response{Content: text.String(), Usage: usage}, errStreamCut
+			}
+			return models.Response{Content: text.String(), Usage: usage}, nil
+		}
+		if err != nil {
+			return models.Response{Content: text.String(), Usage: usage}, fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		if message.Headers[":message-type"] == "exception" || message.Headers[":message-type"] == "error" {
+			var exception struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(message.Payload, &exception)
+			kind := message.Headers[":exception-type"]
+			if kind == "" {
+				kind = message.Headers[":error-code"]
+			}
+			return models.Response{Content: text.String(), Usage: usage}, streamError(kind, exception.Message, message.Payload)
+		}
+
+		switch message.Headers[":event-type"] {
+		case "contentBlockDelta":
+			var event struct {
+				Delta struct {
+					Text string `json:"text"`
+				} `json:"delta"`
+			}
+			if err := json.Unmarshal(message.Payload, &event); err != nil {
+				return models.Response{Content: text.String(), Usage: usage}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+			}
+			if event.Delta.Text != "" {
+				text.WriteString(event.Delta.Text)
+				onToken(event.Delta.Text)
+			}
+		case "metadata":
+			var event struct {
+				Usage bedrockUsage `json:"usage"`
+			}
+			json.Unmarshal(message.Payload, &event)
+			usage = event.Usage.usage()
+		case "messageStop":
+			var event struct {
+				StopReason string `json:"stopReason"`
+			}
+			json.Unmarshal(message.Payload, &event)
+			stopped = true
+			if event.StopReason == "guardrail_intervened" || event.StopReason == "content_filtered" {
+				return models.Response{Content: text.String(), Usage: usage}, contentFiltered("Bedrock stopped the response (" + event.StopReason + ")")
+			}
+		}
+	}
+}
+
+// bedrockUsage is the token counts of a Converse response
+type bedrockUsage struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+}
+
+func (u bedrockUsage) usage() models.Usage {
+	return models.Usage{InputTokens: u.InputTokens, OutputTokens: u.OutputTokens}
+}
+
+// send posts a signed Converse request to endpoint and checks the response status
+func (b *BedrockProvider) send(ctx context.Context, endpoint string, messages []models.Message, temperature float64, client *http.Client) (*http.Response, error) {
+	system, conversation := splitSystemMessages(messages)
+	converseMessages := make([]map[string]any, 0, len(conversation))
+	for _, m := range conversation {
+		converseMessages = append(converseMessages, map[string]any{
+			"role":    m.Role,
+			"content": []map[string]string{{"text": m.Content}},
+		})
+	}
+	requestBody := map[string]any{
+		"messages": converseMessages,
+		"inferenceConfig": map[string]any{
+			"temperature": temperature,
+			"maxTokens":   b.maxTokens,
+		},
+	}
+	if system != "" {
+		requestBody["system"] = []map[string]string{{"text": system}}
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	b.signer.Sign(httpReq, body)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, bedrockError(resp)
+	}
+	return resp, nil
+}
+
+// bedrockError describes a Bedrock error response. The body is
+// {"message": "..."} and the X-Amzn-Errortype header names the exception,
+// such as "ThrottlingException:http://internal.amazon.com/coral/...".
+func bedrockError(resp *http.Response) error {
+	body := readErrorBody(resp)
+	var result struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(body, &result)
+	kind, _, _ := strings.Cut(resp.Header.Get("X-Amzn-Errortype"), ":")
+	return providerError(resp, body, kind, result.Message)
+}
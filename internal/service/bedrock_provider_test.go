@@ -0,0 +1,41 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestBedrockModelPathEscaping(t *testing.T) {
+	var requestURI, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestURI = r.RequestURI
+		auth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"output":{"message":{"content":[{"text":"Adds a line"}]}},"stopReason":"end_turn","usage":{"inputTokens":12,"outputTokens":3}}`)
+	}))
+	defer server.Close()
+
+	provider := NewBedrockProvider(models.BedrockConfig{
+		Model:           "anthropic.claude-3-haiku-20240307-v1:0",
+		Region:          "us-east-1",
+		BaseURL:         server.URL,
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+	})
+	if _, err := provider.GenerateResponse(context.Background(), testMessages, 0.1); err != nil {
+		t.Fatalf("GenerateResponse: %v", err)
+	}
+
+	if want := "/model/anthropic.claude-3-haiku-20240307-v1%3A0/converse"; requestURI != want {
+		t.Errorf("request URI = %q, want %q", requestURI, want)
+	}
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/us-east-1/bedrock/aws4_request") {
+		t.Errorf("Authorization = %q, want a SigV4 signature for bedrock in us-east-1", auth)
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+// replay answers every request of the test from a cassette, and puts the
+// default transport back when the test ends
+func replay(t *testing.T, path string) {
+	t.Helper()
+	if err := transport.Configure(transport.Options{Replay: path}); err != nil {
+		t.Fatalf("replay %s: %v", path, err)
+	}
+	t.Cleanup(func() { transport.Configure(transport.Options{}) })
+}
+
+// cassetteChecks check the outcome of each cassette recorded in
+// testdata/<provider>: a successful response, a truncated JSON body, a 401, a
+// 429 asking to retry after 20 seconds and a 5xx
+var cassetteChecks = map[string]func(*testing.T, models.Response, error){
+	"ok": func(t *testing.T, response models.Response, err error) {
+		if err != nil {
+			t.Fatalf("GenerateResponse: %v", err)
+		}
+		if response.Content != "Adds a line to the README." {
+			t.Errorf("content = %q, want %q", response.Content, "Adds a line to the README.")
+		}
+		if want := (models.Usage{InputTokens: 12, OutputTokens: 3}); response.Usage != want {
+			t.Errorf("usage = %+v, want %+v", response.Usage, want)
+		}
+	},
+	"malformed_json": func(t *testing.T, response models.Response, err error) {
+		if !errors.Is(err, errInvalidResponse) {
+			t.Errorf("error = %v, want %v", err, errInvalidResponse)
+		}
+	},
+	"unauthorized": func(t *testing.T, response models.Response, err error) {
+		var authErr *models.ErrAuth
+		if !errors.As(err, &authErr) {
+			t.Errorf("error = %T %v, want *models.ErrAuth", err, err)
+		}
+	},
+	"rate_limited": func(t *testing.T, response models.Response, err error) {
+		var limited *models.ErrRateLimited
+		if !errors.As(err, &limited) {
+			t.Fatalf("error = %T %v, want *models.ErrRateLimited", err, err)
+		}
+		if limited.RetryAfter != 20*time.Second {
+			t.Errorf("RetryAfter = %s, want 20s", limited.RetryAfter)
+		}
+	},
+	"server_error": func(t *testing.T, response models.Response, err error) {
+		var serverErr *models.ErrServer
+		if !errors.As(err, &serverErr) {
+			t.Errorf("error = %T %v, want *models.ErrServer", err, err)
+		}
+	},
+}
+
+func TestCassettes(t *testing.T) {
+	allCassettes := []string{"ok", "malformed_json", "unauthorized", "rate_limited", "server_error"}
+	tests := []struct {
+		dir      string
+		provider models.LLMProvider
+		// cassettes are the responses the provider can send, all of them when nil
+		cassettes []string
+	}{
+		{dir: "openai", provider: NewOpenAIProvider(models.OpenAIConfig{APIKey: "sk-test", Model: "gpt-4o"})},
+		{dir: "deepseek", provider: NewDeepSeekProvider(models.DeepSeekConfig{APIKey: "sk-test", Model: "deepseek-chat"})},
+		{dir: "openai_compatible", provider: NewOpenAICompatibleProvider(models.OpenAICompatibleConfig{Model: "local-model", BaseURL: "http://localhost:8080/v1"})},
+		{dir: "azure_openai", provider: NewAzureOpenAIProvider(models.AzureOpenAIConfig{
+			APIKey:     "azure-test",
+			Endpoint:   "https://my-resource.openai.azure.com/",
+			Deployment: "gpt-4o",
+			APIVersion: "2024-10-21",
+		})},
+		{dir: "anthropic", provider: NewAnthropicProvider(models.AnthropicConfig{APIKey: "sk-ant-test", Model: "claude-3-5-sonnet-20241022"})},
+		{dir: "gemini", provider: NewGeminiProvider(models.GeminiConfig{APIKey: "gemini-test", Model: "gemini-1.5-flash"})},
+		{dir: "bedrock", provider: NewBedrockProvider(models.BedrockConfig{
+			Model:           "anthropic.claude-3-haiku-20240307-v1:0",
+			Region:          "us-east-1",
+			AccessKeyID:     "AKIDEXAMPLE",
+			SecretAccessKey: "secret",
+		})},
+		// A local Ollama server has no authentication or rate limits
+		{dir: "ollama", provider: NewOllamaProvider(models.OllamaConfig{Model: "llama3.2"}), cassettes: []string{"ok", "malformed_json", "server_error"}},
+	}
+	for _, tt := range tests {
+		cassettes := tt.cassettes
+		if cassettes == nil {
+			cassettes = allCassettes
+		}
+		for _, cassette := range cassettes {
+			t.Run(tt.dir+"/"+cassette, func(t *testing.T) {
+				replay(t, filepath.Join("testdata", tt.dir, cassette+".json"))
+				response, err := tt.provider.GenerateResponse(context.Background(), testMessages, 0.1)
+				cassetteChecks[cassette](t, response, err)
+			})
+		}
+	}
+}
@@ -1,100 +1,36 @@
 package service
 
 import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"time"
-
+	"github.com/deleonn/gopr/internal/budget"
 	"github.com/deleonn/gopr/internal/models"
 )
 
 type DeepSeekProvider struct {
-	apiKey  string
-	model   string
-	baseURL string
+	*OpenAICompatibleProvider
 }
 
 func NewDeepSeekProvider(config models.DeepSeekConfig) *DeepSeekProvider {
 	model := config.Model
 
 	if model == "" {
-		model = "deepseek-chat"
-	}
-
-	return &DeepSeekProvider{
-		apiKey: config.APIKey,
-		model:  model,
-	}
-}
-
-func (d *DeepSeekProvider) GetModel() string {
-	return d.model
-}
-
-func (d *DeepSeekProvider) GetName() string {
-	return "DeepSeek"
-}
-
-func (d *DeepSeekProvider) GenerateResponse(ctx context.Context, prompt string, temperature float64) (string, error) {
-	requestBody := map[string]any{
-		"model":       d.model,
-		"messages":    []map[string]string{{"role": "user", "content": prompt}},
-		"temperature": temperature,
-		"max_tokens":  4000,
-	}
-
-	body, err := json.Marshal(requestBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
-	}
-
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", "https://api.deepseek.com/v1/chat/completions", bytes.NewBuffer(body))
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+d.apiKey)
-
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-	}
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		model = budget.DefaultModel(models.ProviderDeepSeek)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://api.deepseek.com/v1"
 	}
 
-	var result map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
-	}
-
-	choices, ok := result["choices"].([]any)
-	if !ok || len(choices) == 0 {
-		return "", fmt.Errorf("invalid response format: no choices")
-	}
-
-	choice, ok := choices[0].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("invalid response format: invalid choice")
-	}
-
-	message, ok := choice["message"].(map[string]any)
-	if !ok {
-		return "", fmt.Errorf("invalid response format: no message")
-	}
-
-	content, ok := message["content"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid response format: no content")
-	}
+	provider := NewOpenAICompatibleProvider(models.OpenAICompatibleConfig{
+		APIKey:    config.APIKey,
+		Model:     model,
+		BaseURL:   baseURL,
+		Headers:   config.Headers,
+		MaxTokens: config.MaxTokens,
+	})
+	provider.name = "DeepSeek"
+	provider.jsonObjectOnly = true
+	provider.streamUsage = true
 
-	return content, nil
+	return &DeepSeekProvider{provider}
 }
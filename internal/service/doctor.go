@@ -0,0 +1,194 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/deleonn/gopr/internal/git"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+// Check is the outcome of a single diagnostic
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+	// Fix suggests how to solve a failed check
+	Fix string
+}
+
+// Diagnose checks git, the base branch, the configured provider and its
+// fallbacks, returning one result per check in the order they ran
+func Diagnose(config models.Config, branch string) []Check {
+	var checks []Check
+
+	version, err := git.Version()
+	if err != nil {
+		return append(checks, Check{
+			Name:   "git",
+			Detail: fmt.Sprintf("git is not available: %v", err),
+			Fix:    "install git and make sure it is on your PATH",
+		})
+	}
+	checks = append(checks, Check{Name: "git", OK: true, Detail: version})
+
+	root, err := git.TopLevel()
+	if err != nil {
+		checks = append(checks, Check{
+			Name:   "repository",
+			Detail: "not inside a git repository",
+			Fix:    "run gopr from a directory of the repository you want to describe",
+		})
+	} else {
+		checks = append(checks, Check{Name: "repository", OK: true, Detail: root})
+
+		if commit, err := git.ResolveRef(branch); err != nil {
+			checks = append(checks, Check{
+				Name:   "base branch",
+				Detail: fmt.Sprintf("%q does not resolve to a commit", branch),
+				Fix:    fmt.Sprintf("fetch it with `git fetch origin %s:%s`, or pass the right branch with -branch", branch, branch),
+			})
+		} else {
+			checks = append(checks, Check{Name: "base branch", OK: true, Detail: fmt.Sprintf("%s at %.12s", branch, commit)})
+		}
+	}
+
+	primary := config
+	primary.Fallbacks = nil
+	checks = append(checks, diagnoseProvider(primary)...)
+
+	for _, fallback := range config.Fallbacks {
+		if fallback.Provider == config.Provider {
+			continue
+		}
+		if fallback.APIKey == "" && fallback.ResolveKey != nil && fallback.Provider.RequiresAPIKey() {
+			key, err := fallback.ResolveKey()
+			if err != nil {
+				checks = append(checks, Check{
+					Name:   fmt.Sprintf("%s api key", fallback.Provider),
+					Detail: err.Error(),
+					Fix:    fmt.Sprintf("check the key settings of the [provider.%s] section", fallback.Provider),
+				})
+				continue
+			}
+			fallback.APIKey = key
+		}
+		// Name each check after the fallback it belongs to
+		for _, check := range diagnoseProvider(fallback) {
+			check.Name = fmt.Sprintf("%s %s", fallback.Provider, check.Name)
+			checks = append(checks, check)
+		}
+	}
+	return checks
+}
+
+// diagnoseProvider checks that the provider can be created, that its endpoint
+// resolves and that it accepts the configured key
+func diagnoseProvider(config models.Config) []Check {
+	if config.Provider.RequiresAPIKey() && config.APIKey == "" {
+		return []Check{{
+			Name:   "api key",
+			Detail: fmt.Sprintf("no API key configured for %s", config.Provider),
+			Fix:    fmt.Sprintf("set api_key or api_key_cmd, export GOPR_API_KEY, or run `gopr config set-key %s`", config.Provider),
+		}}
+	}
+
+	provider, err := NewProviderFactory().CreateProvider(config)
+	if err != nil {
+		return []Check{{
+			Name:   "provider",
+			Detail: err.Error(),
+			Fix:    "check the provider settings with `gopr config show` and `gopr config validate`",
+		}}
+	}
+	checks := []Check{{Name: "provider", OK: true, Detail: fmt.Sprintf("%s, model %s", provider.GetName(), provider.GetModel())}}
+
+	diagnoser, ok := provider.(models.Diagnoser)
+	if !ok {
+		return checks
+	}
+
+	endpoint := diagnoser.Endpoint()
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return append(checks, Check{
+			Name:   "endpoint",
+			Detail: fmt.Sprintf("invalid endpoint %q", endpoint),
+			Fix:    "check base_url",
+		})
+	}
+	if _, err := net.LookupHost(u.Hostname()); err != nil {
+		return append(checks, Check{
+			Name:   "endpoint",
+			Detail: fmt.Sprintf("cannot resolve host %s of %s", u.Hostname(), endpoint),
+			Fix:    "check base_url for typos, and your network or proxy settings",
+		})
+	}
+	checks = append(checks, Check{Name: "endpoint", OK: true, Detail: endpoint})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if err := diagnoser.Ping(ctx); err != nil {
+		err = redactError(err, config.APIKey)
+		return append(checks, Check{
+			Name:   "connection",
+			Detail: err.Error(),
+			Fix:    pingFix(config, provider, err),
+		})
+	}
+	return append(checks, Check{Name: "connection", OK: true, Detail: "endpoint reachable and credentials accepted"})
+}
+
+// pingFix suggests how to solve a failed provider ping
+func pingFix(config models.Config, provider models.LLMProvider, err error) string {
+	var auth *models.ErrAuth
+	var apiErr *models.APIError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errModelNotFound):
+		return fmt.Sprintf("pull the model with `ollama pull %s`, or pick an installed one with -model", provider.GetModel())
+	case errors.Is(err, syscall.ECONNREFUSED):
+		if config.Provider == models.ProviderOllama {
+			return "start Ollama with `ollama serve`, or point base_url at a running instance"
+		}
+		return "check base_url and that the server is running"
+	case errors.As(err, &auth):
+		return "the API key was rejected, check that it is valid and belongs to this provider"
+	case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+		return "the endpoint does not exist, check base_url"
+	case errors.As(err, &apiErr) && apiErr.StatusCode >= 500:
+		return "the provider is having problems, try again later"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the endpoint did not answer in time, check your network or proxy settings"
+	}
+	return "check the provider settings with `gopr config show`"
+}
+
+// pingEndpoint sends an authenticated GET request and checks that it succeeds
+func pingEndpoint(ctx context.Context, endpoint string, headers map[string]string) error {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, value := range headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return providerError(resp, readErrorBody(resp), "", "")
+	}
+	return nil
+}
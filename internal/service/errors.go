@@ -0,0 +1,153 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+var (
+	// errModelNotFound is returned when the configured model is not available
+	errModelNotFound = errors.New("model not found")
+	// errInvalidResponse is returned when a response can't be decoded or has no text
+	errInvalidResponse = errors.New("invalid response format")
+	// errChainExhausted is returned when every provider of a fallback chain was given up on
+	errChainExhausted = errors.New("no provider in the chain could answer")
+	// ErrNoModelList is returned by ListModels when the provider can't list its models
+	ErrNoModelList = errors.New("the provider can't list its models")
+)
+
+// maxErrorBody bounds how much of an error response is read
+const maxErrorBody = 64 * 1024
+
+// Error codes that identify an error regardless of the status code, which
+// errors sent inside a stream don't have. Codes are lowercase.
+var (
+	rateLimitCodes = map[string]bool{
+		"rate_limit_exceeded": true, // OpenAI
+		"rate_limit_error":    true, // Anthropic
+		"resource_exhausted":  true, // Gemini
+		"throttlingexception": true, // Bedrock
+	}
+	authCodes = map[string]bool{
+		"invalid_api_key":             true, // OpenAI
+		"authentication_error":        true, // Anthropic
+		"permission_error":            true, // Anthropic
+		"unauthenticated":             true, // Gemini
+		"permission_denied":           true, // Gemini
+		"accessdeniedexception":       true, // Bedrock
+		"unrecognizedclientexception": true, // Bedrock
+		"expiredtokenexception":       true, // Bedrock
+	}
+	serverCodes = map[string]bool{
+		"server_error":                true, // OpenAI
+		"api_error":                   true, // Anthropic
+		"overloaded_error":            true, // Anthropic
+		"internal":                    true, // Gemini
+		"unavailable":                 true, // Gemini
+		"internalserverexception":     true, // Bedrock
+		"serviceunavailableexception": true, // Bedrock
+		"modelstreamerrorexception":   true, // Bedrock
+	}
+)
+
+// contextTooLongMessages are parts of the messages providers send when the
+// prompt exceeds the context window, as most have no dedicated error code
+var contextTooLongMessages = []string{
+	"maximum context length",               // OpenAI, DeepSeek
+	"prompt is too long",                   // Anthropic
+	"exceeds the maximum number of tokens", // Gemini
+	"input is too long",                    // Bedrock
+	"too many input tokens",                // Bedrock
+	"context window",                       // llama.cpp and other OpenAI-compatible servers
+}
+
+// readErrorBody reads the body of an unsuccessful response
+func readErrorBody(resp *http.Response) []byte {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return body
+}
+
+// providerError turns an error response into one of the error types of the
+// models package. code and message come from the provider's error JSON, and
+// are empty when the body had none.
+func providerError(resp *http.Response, body []byte, code, message string) error {
+	apiErr := models.APIError{
+		StatusCode: resp.StatusCode,
+		Code:       code,
+		Message:    message,
+		Body:       string(body),
+	}
+	return classifyError(apiErr, retryAfter(resp.Header))
+}
+
+// streamError classifies an error sent inside a stream, which only has the provider's code
+func streamError(code, message string, body []byte) error {
+	return classifyError(models.APIError{Code: code, Message: message, Body: string(body)}, 0)
+}
+
+// classifyError picks the error type from the status code, falling back to
+// the provider's error code
+func classifyError(apiErr models.APIError, retryAfter time.Duration) error {
+	code := strings.ToLower(apiErr.Code)
+	switch {
+	case code == "insufficient_quota":
+		// OpenAI sends 429 when the account ran out of credit, which retrying won't fix
+		return &apiErr
+	case apiErr.StatusCode == http.StatusTooManyRequests || rateLimitCodes[code]:
+		return &models.ErrRateLimited{APIError: apiErr, RetryAfter: retryAfter}
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden || authCodes[code]:
+		return &models.ErrAuth{APIError: apiErr}
+	case apiErr.StatusCode == http.StatusRequestEntityTooLarge || contextTooLong(apiErr):
+		return &models.ErrContextTooLong{APIError: apiErr}
+	case apiErr.StatusCode >= 500 || serverCodes[code]:
+		return &models.ErrServer{APIError: apiErr}
+	}
+	return &apiErr
+}
+
+// contextTooLong reports whether an error says the prompt exceeds the context window
+func contextTooLong(apiErr models.APIError) bool {
+	if apiErr.Code == "context_length_exceeded" {
+		return true
+	}
+	message := strings.ToLower(apiErr.Message)
+	for _, part := range contextTooLongMessages {
+		if strings.Contains(message, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// contentFiltered is returned when a content filter stopped a response that
+// had already been accepted
+func contentFiltered(message string) error {
+	return &models.ErrContentFiltered{APIError: models.APIError{Message: message}}
+}
+
+// retryAfter reads how long the provider asked to wait before retrying, from
+// the retry-after-ms header of OpenAI and Azure or the standard Retry-After
+func retryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
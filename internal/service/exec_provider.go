@@ -0,0 +1,125 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// execTimeout bounds a single plugin run
+const execTimeout = 5 * time.Minute
+
+// execRequest is written to the plugin's stdin
+type execRequest struct {
+	Model       string           `json:"model,omitempty"`
+	Messages    []models.Message `json:"messages"`
+	Temperature float64          `json:"temperature"`
+	MaxTokens   int              `json:"max_tokens"`
+	APIKey      string           `json:"api_key,omitempty"`
+	BaseURL     string           `json:"base_url,omitempty"`
+}
+
+// execResponse is read from the plugin's stdout
+type execResponse struct {
+	Content string `json:"content"`
+	Error   string `json:"error"`
+	// Usage is optional, for plugins that know the token counts
+	Usage models.Usage `json:"usage"`
+}
+
+// ExecProvider runs an external plugin for each request, in the style of git
+// credential helpers. The plugin reads an execRequest as JSON on stdin and
+// writes an execResponse as JSON on stdout, setting either content or error.
+type ExecProvider struct {
+	path      string
+	model     string
+	apiKey    string
+	baseURL   string
+	maxTokens int
+}
+
+func NewExecProvider(config models.ExecConfig) *ExecProvider {
+	return &ExecProvider{
+		path:      config.Path,
+		model:     config.Model,
+		apiKey:    config.APIKey,
+		baseURL:   config.BaseURL,
+		maxTokens: cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
+	}
+}
+
+func (e *ExecProvider) GetModel() string {
+	if e.model == "" {
+		return "plugin default"
+	}
+	return e.model
+}
+
+func (e *ExecProvider) GetName() string {
+	return "Plugin " + filepath.Base(e.path)
+}
+
+func (e *ExecProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	request, err := json.Marshal(execRequest{
+		Model:       e.model,
+		Messages:    messages,
+		Temperature: temperature,
+		MaxTokens:   e.maxTokens,
+		APIKey:      e.apiKey,
+		BaseURL:     e.baseURL,
+	})
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, execTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.path)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	var response execResponse
+	decodeErr := json.Unmarshal(stdout.Bytes(), &response)
+	switch {
+	case decodeErr == nil && response.Error != "":
+		return models.Response{}, fmt.Errorf("plugin %s: %s", e.path, response.Error)
+	case runErr != nil:
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return models.Response{}, fmt.Errorf("plugin %s did not answer within %v", e.path, execTimeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return models.Response{}, fmt.Errorf("plugin %s failed: %v: %s", e.path, runErr, msg)
+		}
+		return models.Response{}, fmt.Errorf("plugin %s failed: %v", e.path, runErr)
+	case decodeErr != nil:
+		return models.Response{}, fmt.Errorf("%w: plugin %s wrote invalid JSON: %v", errInvalidResponse, e.path, decodeErr)
+	case response.Content == "":
+		return models.Response{}, fmt.Errorf("%w: no content", errInvalidResponse)
+	}
+	return models.Response{Content: response.Content, Usage: response.Usage}, nil
+}
+
+// GenerateStream runs the plugin like GenerateResponse, as the protocol has
+// no streaming, and passes the whole response to onToken at once
+func (e *ExecProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	response, err := e.GenerateResponse(ctx, messages, temperature)
+	if err != nil {
+		return models.Response{}, err
+	}
+	onToken(response.Content)
+	return response, nil
+}
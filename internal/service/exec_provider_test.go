@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// writePlugin writes a shell script plugin that saves its request next to
+// itself as request.json, then runs body
+func writePlugin(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	path := filepath.Join(t.TempDir(), "gopr-plugin")
+	script := "#!/bin/sh\ncat > \"$(dirname \"$0\")/request.json\"\n" + body + "\n"
+	if err := os.WriteFile(path, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    models.Response
+		wantErr string
+		// invalid is whether the error is errInvalidResponse, which is retried
+		invalid bool
+	}{
+		{
+			name: "success",
+			body: `echo '{"content": "Adds a line to the README.", "usage": {"input_tokens": 12, "output_tokens": 3}}'`,
+			want: models.Response{Content: "Adds a line to the README.", Usage: models.Usage{InputTokens: 12, OutputTokens: 3}},
+		},
+		{
+			name:    "error field",
+			body:    `echo '{"error": "gateway quota exceeded"}'; exit 1`,
+			wantErr: ": gateway quota exceeded",
+		},
+		{
+			name:    "invalid JSON",
+			body:    `echo 'Adds a line to the README.'`,
+			wantErr: " wrote invalid JSON: invalid character 'A' looking for beginning of value",
+			invalid: true,
+		},
+		{
+			name:    "no content",
+			body:    `echo '{}'`,
+			wantErr: "invalid response format: no content",
+			invalid: true,
+		},
+		{
+			name:    "non-zero exit",
+			body:    `echo 'gateway unreachable' >&2; exit 3`,
+			wantErr: " failed: exit status 3: gateway unreachable",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writePlugin(t, tt.body)
+			provider := NewExecProvider(models.ExecConfig{Path: path, Model: "gateway-model", APIKey: "secret", MaxTokens: 1024})
+
+			response, err := provider.GenerateResponse(context.Background(), testMessages, 0.1)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("GenerateResponse: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.HasSuffix(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want it to end with %q", err, tt.wantErr)
+			case errors.Is(err, errInvalidResponse) != tt.invalid:
+				t.Errorf("error = %v, want errInvalidResponse %v", err, tt.invalid)
+			case response != tt.want:
+				t.Errorf("response = %+v, want %+v", response, tt.want)
+			}
+
+			data, err := os.ReadFile(filepath.Join(filepath.Dir(path), "request.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var request execRequest
+			if err := json.Unmarshal(data, &request); err != nil {
+				t.Fatalf("plugin read invalid JSON: %v\n%s", err, data)
+			}
+			if request.Model != "gateway-model" || request.APIKey != "secret" || request.MaxTokens != 1024 || len(request.Messages) != len(testMessages) {
+				t.Errorf("plugin read %+v", request)
+			}
+		})
+	}
+}
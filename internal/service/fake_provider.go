@@ -0,0 +1,312 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// fakeTemplate is the built-in response of the fake provider, in the default
+// PR description format
+const fakeTemplate = `# TL;DR
+Changes on {{.Branch}}{{with .Base}} since {{.}}{{end}}: {{len .Commits}} commit(s) touching {{len .Files}} file(s).
+
+# What's changed?
+{{range .Commits}}- {{.}}
+{{else}}- No commits since the base branch
+{{end}}
+# How to test?
+1. Review the changed files:{{range .Files}} ` + "`{{.}}`" + `{{end}}
+
+# Why make this change?
+This description was written by gopr's fake provider, without any LLM.
+
+# Breaking changes or important notes
+- None detected by the fake provider
+`
+
+// fakeGeneric is a response too generic to pass the validation of the description
+const fakeGeneric = `# TL;DR
+General improvements to the codebase.
+
+# What's changed?
+- Improved functionality and better performance
+`
+
+// fakeGenericDescription is the structured form of fakeGeneric
+var fakeGenericDescription = models.PRDescription{
+	Summary: "General improvements to the codebase.",
+	Changes: []string{"Improved functionality and better performance"},
+}
+
+// FakeData is what a fake response template can use, read back from the prompt
+type FakeData struct {
+	Branch string
+	// Base is the branch the changes are compared with
+	Base    string
+	Commits []string
+	// FileAnalysis is the summary of the changed file types
+	FileAnalysis string
+	Files        []string
+	Diff         string
+}
+
+// FakeProvider answers without any LLM, from a built-in template or a
+// fixture file, and can simulate latency, errors and generic responses. It
+// is meant for demos and for exercising the retry and validation logic.
+type FakeProvider struct {
+	model    string
+	response string
+	latency  time.Duration
+
+	mu sync.Mutex
+	// errors and generic are used up by successive requests
+	errors  []string
+	generic int
+}
+
+func NewFakeProvider(config models.FakeConfig) *FakeProvider {
+	return &FakeProvider{
+		model:    config.Model,
+		response: config.Response,
+		latency:  config.Latency,
+		errors:   append([]string(nil), config.Errors...),
+		generic:  config.Generic,
+	}
+}
+
+func (f *FakeProvider) GetModel() string {
+	if f.model == "" {
+		return "fake"
+	}
+	return f.model
+}
+
+func (f *FakeProvider) GetName() string {
+	return "Fake"
+}
+
+// ListModels returns the configured model, which is the only one the fake offers
+func (f *FakeProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	return []models.ModelInfo{{Name: f.GetModel()}}, nil
+}
+
+func (f *FakeProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	return f.generate(ctx, messages, false)
+}
+
+// GenerateStructured answers with a PR description as JSON, or with the
+// rendered fixture file, which should then hold the JSON
+func (f *FakeProvider) GenerateStructured(ctx context.Context, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	return f.generate(ctx, messages, true)
+}
+
+// generate answers a request once the latency has passed
+func (f *FakeProvider) generate(ctx context.Context, messages []models.Message, structured bool) (models.Response, error) {
+	content, err := f.next(messages, structured)
+	if err := sleepContext(ctx, f.latency); err != nil {
+		return models.Response{}, err
+	}
+	if err != nil {
+		return models.Response{}, err
+	}
+	return models.Response{Content: content, Usage: fakeUsage(messages, content)}, nil
+}
+
+// GenerateStream sends the response a word at a time, spreading the latency
+// over the words
+func (f *FakeProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	content, err := f.next(messages, false)
+	if err != nil {
+		if err := sleepContext(ctx, f.latency); err != nil {
+			return models.Response{}, err
+		}
+		return models.Response{}, err
+	}
+
+	words := strings.SplitAfter(content, " ")
+	delay := f.latency / time.Duration(len(words))
+	var text strings.Builder
+	for _, word := range words {
+		if err := sleepContext(ctx, delay); err != nil {
+			return models.Response{Content: text.String()}, err
+		}
+		text.WriteString(word)
+		onToken(word)
+	}
+	return models.Response{Content: content, Usage: fakeUsage(messages, content)}, nil
+}
+
+// next returns the response to a request: the next simulated error, then a
+// generic response, then the rendered template. Structured responses are JSON.
+func (f *FakeProvider) next(messages []models.Message, structured bool) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.errors) > 0 {
+		name := f.errors[0]
+		f.errors = f.errors[1:]
+		return "", fakeError(name)
+	}
+	if f.generic > 0 {
+		f.generic--
+		if structured {
+			return marshalDescription(fakeGenericDescription)
+		}
+		return fakeGeneric, nil
+	}
+	if structured && f.response == "" {
+		return marshalDescription(fakeDescription(parseFakeData(messages)))
+	}
+
+	text := fakeTemplate
+	if f.response != "" {
+		data, err := os.ReadFile(f.response)
+		if err != nil {
+			return "", fmt.Errorf("failed to read fake response: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("response").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse fake response: %w", err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, parseFakeData(messages)); err != nil {
+		return "", fmt.Errorf("failed to render fake response: %w", err)
+	}
+	return out.String(), nil
+}
+
+// fakeDescription is the structured form of fakeTemplate
+func fakeDescription(data FakeData) models.PRDescription {
+	summary := fmt.Sprintf("Changes on %s", data.Branch)
+	if data.Base != "" {
+		summary += " since " + data.Base
+	}
+	summary += fmt.Sprintf(": %d commit(s) touching %d file(s).", len(data.Commits), len(data.Files))
+
+	description := models.PRDescription{
+		Summary:    summary,
+		Changes:    data.Commits,
+		Motivation: "This description was written by gopr's fake provider, without any LLM.",
+		Notes:      []string{"None detected by the fake provider"},
+	}
+	if len(description.Changes) == 0 {
+		description.Changes = []string{"No commits since the base branch"}
+	}
+	if len(data.Files) > 0 {
+		description.TestSteps = []string{"Review the changed files: `" + strings.Join(data.Files, "`, `") + "`"}
+	}
+	return description
+}
+
+func marshalDescription(description models.PRDescription) (string, error) {
+	data, err := json.Marshal(description)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal fake description: %w", err)
+	}
+	return string(data), nil
+}
+
+// fakeError builds the error a provider would return for one of models.FakeErrors
+func fakeError(name string) error {
+	switch name {
+	case "rate_limit":
+		return &models.ErrRateLimited{
+			APIError:   models.APIError{StatusCode: 429, Code: "rate_limit_exceeded", Message: "simulated rate limit"},
+			RetryAfter: time.Second,
+		}
+	case "server":
+		return &models.ErrServer{APIError: models.APIError{StatusCode: 500, Code: "server_error", Message: "simulated server error"}}
+	case "auth":
+		return &models.ErrAuth{APIError: models.APIError{StatusCode: 401, Code: "invalid_api_key", Message: "simulated authentication failure"}}
+	case "context_too_long":
+		return &models.ErrContextTooLong{APIError: models.APIError{StatusCode: 400, Code: "context_length_exceeded", Message: "simulated prompt too long"}}
+	case "content_filter":
+		return &models.ErrContentFiltered{APIError: models.APIError{StatusCode: 400, Code: "content_filter", Message: "simulated content filter"}}
+	case "network":
+		// The error an http.Client returns when the provider can't be reached
+		return &url.Error{Op: "Post", URL: "fake://", Err: errors.New("simulated network failure")}
+	case "invalid":
+		return fmt.Errorf("%w: simulated invalid response", errInvalidResponse)
+	}
+	return fmt.Errorf("unknown fake error %q", name)
+}
+
+// parseFakeData reads the branch, commits, file analysis and diff back from
+// the user message written by PRService.userPrompt
+func parseFakeData(messages []models.Message) FakeData {
+	var data FakeData
+	for _, m := range messages {
+		if m.Role != models.RoleUser {
+			continue
+		}
+		context, diff, _ := strings.Cut(m.Content, "<git_diff>\n")
+		data.Diff, _, _ = strings.Cut(diff, "</git_diff>")
+		context, _, _ = strings.Cut(context, "</repository_context>")
+
+		inCommits := false
+		for _, line := range strings.Split(context, "\n") {
+			switch {
+			case strings.HasPrefix(line, "Current branch: "):
+				data.Branch = strings.TrimPrefix(line, "Current branch: ")
+			case strings.HasPrefix(line, "Number of commits since "):
+				data.Base, _, _ = strings.Cut(strings.TrimPrefix(line, "Number of commits since "), ":")
+			case line == "Commit messages:":
+				inCommits = true
+			case inCommits && strings.HasPrefix(line, "- "):
+				data.Commits = append(data.Commits, strings.TrimPrefix(line, "- "))
+			default:
+				inCommits = false
+			}
+		}
+		if i := strings.Index(context, "## File Analysis"); i >= 0 {
+			data.FileAnalysis = strings.TrimSpace(context[i:])
+		}
+
+		for _, line := range strings.Split(data.Diff, "\n") {
+			if rest, ok := strings.CutPrefix(line, "diff --git "); ok {
+				if i := strings.LastIndex(rest, " b/"); i >= 0 {
+					data.Files = append(data.Files, rest[i+3:])
+				}
+			}
+		}
+	}
+	return data
+}
+
+// fakeUsage estimates the token counts a real provider would report
+func fakeUsage(messages []models.Message, content string) models.Usage {
+	input := 0
+	for _, m := range messages {
+		input += budget.EstimateTokens(m.Content)
+	}
+	return models.Usage{InputTokens: input, OutputTokens: budget.EstimateTokens(content)}
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
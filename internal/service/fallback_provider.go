@@ -0,0 +1,193 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// maxInvalidResponses is how many invalid responses in a row make the chain
+// give up on a provider
+const maxInvalidResponses = 2
+
+// FallbackProvider tries a chain of providers in order. It moves on to the
+// next one when a provider can't be created or reached, rejects its
+// credentials, or keeps sending invalid responses. A provider it gave up on
+// is skipped for the rest of the run.
+type FallbackProvider struct {
+	factory *ProviderFactory
+	links   []*fallbackLink
+	// current is the index of the first provider not given up on
+	current int
+	// answered is the provider that produced the last response
+	answered     models.LLMProvider
+	answeredType models.ProviderType
+	// failures records why each provider was given up on
+	failures []string
+	log      io.Writer
+}
+
+// fallbackLink is a provider of the chain, created when it is first needed
+type fallbackLink struct {
+	config   models.Config
+	provider models.LLMProvider
+	// key is removed from the errors of the provider
+	key     string
+	invalid int
+}
+
+func NewFallbackProvider(factory *ProviderFactory, configs []models.Config) *FallbackProvider {
+	f := &FallbackProvider{factory: factory, log: io.Discard}
+	seen := make(map[models.ProviderType]bool)
+	for _, config := range configs {
+		if seen[config.Provider] {
+			continue
+		}
+		seen[config.Provider] = true
+		f.links = append(f.links, &fallbackLink{config: config})
+	}
+	return f
+}
+
+// SetLog makes the chain report on w when it moves to another provider
+func (f *FallbackProvider) SetLog(w io.Writer) {
+	f.log = w
+}
+
+// GetName describes the chain, such as "ollama -> deepseek"
+func (f *FallbackProvider) GetName() string {
+	names := make([]string, len(f.links))
+	for i, link := range f.links {
+		names[i] = string(link.config.Provider)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// GetModel returns the model of the first provider of the chain, which is
+// the provider's default model when none is configured
+func (f *FallbackProvider) GetModel() string {
+	config := f.links[0].config
+	return cmp.Or(config.Model, budget.DefaultModel(config.Provider))
+}
+
+// Answered returns the provider that produced the last response, or nil
+func (f *FallbackProvider) Answered() models.LLMProvider {
+	return f.answered
+}
+
+// AnsweredType returns the provider type of Answered
+func (f *FallbackProvider) AnsweredType() models.ProviderType {
+	return f.answeredType
+}
+
+func (f *FallbackProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	return f.generate(func(provider models.LLMProvider, streamed *bool) (models.Response, error) {
+		return provider.GenerateResponse(ctx, messages, temperature)
+	})
+}
+
+func (f *FallbackProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	return f.generate(func(provider models.LLMProvider, streamed *bool) (models.Response, error) {
+		return provider.GenerateStream(ctx, messages, temperature, func(token string) {
+			*streamed = true
+			onToken(token)
+		})
+	})
+}
+
+// GenerateStructured asks each provider of the chain for JSON matching
+// schema, through its own mechanism when it has one
+func (f *FallbackProvider) GenerateStructured(ctx context.Context, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	return f.generate(func(provider models.LLMProvider, streamed *bool) (models.Response, error) {
+		return generateStructured(ctx, provider, messages, temperature, schema)
+	})
+}
+
+// generate calls each provider in turn until one answers or fails with an
+// error that the next provider would not avoid
+func (f *FallbackProvider) generate(call func(provider models.LLMProvider, streamed *bool) (models.Response, error)) (models.Response, error) {
+	for f.current < len(f.links) {
+		link := f.links[f.current]
+		provider, err := f.create(link)
+		if err != nil {
+			f.giveUp(link, err)
+			continue
+		}
+
+		streamed := false
+		response, err := call(provider, &streamed)
+		if err == nil {
+			link.invalid = 0
+			f.answered = provider
+			f.answeredType = link.config.Provider
+			return response, nil
+		}
+		err = redactError(err, link.key)
+
+		if errors.Is(err, errInvalidResponse) {
+			link.invalid++
+		}
+		// Text already written can't be taken back, so the rest of the
+		// response must come from the same provider
+		if streamed || !shouldFallBack(err, link.invalid) {
+			return response, fmt.Errorf("%s: %w", link.config.Provider, err)
+		}
+		f.giveUp(link, err)
+	}
+	return models.Response{}, fmt.Errorf("%w: %s", errChainExhausted, strings.Join(f.failures, "; "))
+}
+
+// create returns the provider of a link, creating it and looking up its key on first use
+func (f *FallbackProvider) create(link *fallbackLink) (models.LLMProvider, error) {
+	if link.provider != nil {
+		return link.provider, nil
+	}
+
+	config := link.config
+	if config.APIKey == "" && config.ResolveKey != nil && config.Provider.RequiresAPIKey() {
+		key, err := config.ResolveKey()
+		if err != nil {
+			return nil, err
+		}
+		config.APIKey = key
+	}
+
+	provider, err := f.factory.CreateProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	link.provider = provider
+	link.key = config.APIKey
+	return provider, nil
+}
+
+// giveUp moves the chain past a provider
+func (f *FallbackProvider) giveUp(link *fallbackLink, err error) {
+	f.failures = append(f.failures, fmt.Sprintf("%s: %v", link.config.Provider, err))
+	f.current++
+	if f.current < len(f.links) {
+		fmt.Fprintf(f.log, "Provider %s failed (%v), falling back to %s\n", link.config.Provider, err, f.links[f.current].config.Provider)
+	}
+}
+
+// shouldFallBack reports whether an error means the provider can't answer
+// at all: it can't be reached, it rejects the credentials, or it sent too
+// many invalid responses in a row
+func shouldFallBack(err error, invalidResponses int) bool {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return true
+	}
+	var auth *models.ErrAuth
+	if errors.As(err, &auth) {
+		return true
+	}
+	return errors.Is(err, errInvalidResponse) && invalidResponses >= maxInvalidResponses
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestFallbackGetModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		primary models.Config
+		want    string
+	}{
+		{name: "configured model", primary: models.Config{Provider: models.ProviderAnthropic, Model: "claude-3-5-haiku-20241022"}, want: "claude-3-5-haiku-20241022"},
+		{name: "provider default", primary: models.Config{Provider: models.ProviderOpenAI}, want: "gpt-4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := NewFallbackProvider(NewProviderFactory(), []models.Config{tt.primary, {Provider: models.ProviderFake}})
+			if got := chain.GetModel(); got != tt.want {
+				t.Errorf("GetModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestChain returns a chain of the fake provider failing with fakeErrors,
+// then an OpenAI-compatible server answering from cassette
+func newTestChain(t *testing.T, fakeErrors []string, cassette string) *FallbackProvider {
+	t.Helper()
+	replay(t, filepath.Join("testdata", "openai_compatible", cassette+".json"))
+	return NewFallbackProvider(NewProviderFactory(), []models.Config{
+		{Provider: models.ProviderFake, Fake: models.FakeConfig{Errors: fakeErrors}},
+		{Provider: models.ProviderOpenAICompatible, Model: "local-model", BaseURL: "http://localhost:8080/v1"},
+	})
+}
+
+func TestFallbackMovesOn(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors []string
+		// failedCalls is how many calls fail before the fallback answers
+		failedCalls int
+	}{
+		{name: "network failure", errors: []string{"network"}},
+		{name: "rejected credentials", errors: []string{"auth"}},
+		{name: "two invalid responses", errors: []string{"invalid", "invalid"}, failedCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain(t, tt.errors, "ok")
+			for range tt.failedCalls {
+				if _, err := chain.GenerateResponse(context.Background(), testMessages, 0.1); !errors.Is(err, errInvalidResponse) {
+					t.Fatalf("error = %v, want %v from the primary", err, errInvalidResponse)
+				}
+			}
+
+			response, err := chain.GenerateResponse(context.Background(), testMessages, 0.1)
+			if err != nil {
+				t.Fatalf("GenerateResponse: %v", err)
+			}
+			if response.Content != "Adds a line to the README." || chain.AnsweredType() != models.ProviderOpenAICompatible {
+				t.Errorf("%s answered %q, want the fallback", chain.AnsweredType(), response.Content)
+			}
+		})
+	}
+}
+
+func TestFallbackStays(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors []string
+		// errAs is a pointer to the error type expected, errIs the error expected otherwise
+		errAs any
+		errIs error
+	}{
+		{name: "rate limit", errors: []string{"rate_limit"}, errAs: new(*models.ErrRateLimited)},
+		{name: "server error", errors: []string{"server"}, errAs: new(*models.ErrServer)},
+		{name: "context too long", errors: []string{"context_too_long"}, errAs: new(*models.ErrContextTooLong)},
+		{name: "one invalid response", errors: []string{"invalid"}, errIs: errInvalidResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := newTestChain(t, tt.errors, "ok")
+			_, err := chain.GenerateResponse(context.Background(), testMessages, 0.1)
+			if (tt.errAs != nil && !errors.As(err, tt.errAs)) || (tt.errIs != nil && !errors.Is(err, tt.errIs)) {
+				t.Fatalf("error = %T %v, want %T %v", err, err, tt.errAs, tt.errIs)
+			}
+			if chain.current != 0 || chain.Answered() != nil {
+				t.Errorf("chain moved to provider %d after %v", chain.current, err)
+			}
+
+			// The primary answers once its errors are used up
+			if _, err := chain.GenerateResponse(context.Background(), testMessages, 0.1); err != nil || chain.AnsweredType() != models.ProviderFake {
+				t.Errorf("%s answered with error %v, want the primary", chain.AnsweredType(), err)
+			}
+		})
+	}
+}
+
+func TestFallbackExhausted(t *testing.T) {
+	chain := newTestChain(t, []string{"network"}, "unauthorized")
+	_, err := chain.GenerateResponse(context.Background(), testMessages, 0.1)
+	if !errors.Is(err, errChainExhausted) {
+		t.Fatalf("error = %v, want %v", err, errChainExhausted)
+	}
+	if retryable(err) {
+		t.Errorf("retryable(%v) = true, want false", err)
+	}
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+type GeminiProvider struct {
+	apiKey    string
+	model     string
+	baseURL   string
+	maxTokens int
+}
+
+func NewGeminiProvider(config models.GeminiConfig) *GeminiProvider {
+	model := config.Model
+
+	if model == "" {
+		model = budget.DefaultModel(models.ProviderGemini)
+	}
+
+	baseURL := config.BaseURL
+	if baseURL == "" {
+		baseURL = "https://generativelanguage.googleapis.com/v1beta"
+	}
+
+	return &GeminiProvider{
+		apiKey:    config.APIKey,
+		model:     model,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		maxTokens: cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
+	}
+}
+
+func (g *GeminiProvider) GetModel() string {
+	return g.model
+}
+
+func (g *GeminiProvider) GetName() string {
+	return "Gemini"
+}
+
+func (g *GeminiProvider) Endpoint() string {
+	return g.modelURL() + ":generateContent"
+}
+
+func (g *GeminiProvider) Ping(ctx context.Context) error {
+	return pingEndpoint(ctx, g.modelURL(), map[string]string{"x-goog-api-key": g.apiKey})
+}
+
+// modelURL is the resource URL of the configured model
+func (g *GeminiProvider) modelURL() string {
+	return g.baseURL + "/models/" + url.PathEscape(strings.TrimPrefix(g.model, "models/"))
+}
+
+func (g *GeminiProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	resp, err := g.send(ctx, g.Endpoint(), messages, temperature, transport.Client())
+	if err != nil {
+		return models.Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var result geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return models.Response{}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+	}
+
+	text, err := result.text()
+	if err != nil {
+		return models.Response{}, err
+	}
+	if text == "" {
+		return models.Response{}, fmt.Errorf("%w: no text", errInvalidResponse)
+	}
+	return models.Response{Content: text, Usage: result.usage()}, nil
+}
+
+func (g *GeminiProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	resp, err := g.send(ctx, g.modelURL()+":streamGenerateContent?alt=sse", messages, temperature, transport.StreamClient())
+	if err != nil {
+		return models.Response{}, err
+	}
+	defer resp.Body.Close()
+
+	var text strings.Builder
+	var usage models.Usage
+	err = readSSE(resp.Body, func(event, data string) error {
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+		}
+		if chunk.UsageMetadata.PromptTokenCount > 0 {
+			usage = chunk.usage()
+		}
+		token, err := chunk.text()
+		if token != "" {
+			text.WriteString(token)
+			onToken(token)
+		}
+		if err == nil && len(chunk.Candidates) > 0 && chunk.Candidates[0].FinishReason != "" {
+			// Gemini sends no end marker, the chunk with a finish reason is the last
+			return errStreamDone
+		}
+		return err
+	})
+	return models.Response{Content: text.String(), Usage: usage}, err
+}
+
+// send posts a generateContent request to endpoint and checks the response status
+func (g *GeminiProvider) send(ctx context.Context, endpoint string, messages []models.Message, temperature float64, client *http.Client) (*http.Response, error) {
+	system, contents := geminiContents(messages)
+	requestBody := map[string]any{
+		"contents": contents,
+		"generationConfig": map[string]any{
+			"temperature":     temperature,
+			"maxOutputTokens": g.maxTokens,
+		},
+	}
+	if system != "" {
+		requestBody["systemInstruction"] = map[string]any{
+			"parts": []map[string]string{{"text": system}},
+		}
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-goog-api-key", g.apiKey)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, geminiError(resp)
+	}
+	return resp, nil
+}
+
+// geminiError describes a Gemini error response, whose body is
+// {"error": {"message": "...", "status": "...", "details": [...]}}. An
+// invalid key is a bad request with an API_KEY_INVALID reason, and rate
+// limits give the time to wait in a RetryInfo detail rather than a header.
+func geminiError(resp *http.Response) error {
+	body := readErrorBody(resp)
+	var result struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				Type       string `json:"@type"`
+				Reason     string `json:"reason"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &result)
+
+	for _, detail := range result.Error.Details {
+		if detail.Reason == "API_KEY_INVALID" {
+			return &models.ErrAuth{APIError: models.APIError{
+				StatusCode: resp.StatusCode,
+				Code:       detail.Reason,
+				Message:    result.Error.Message,
+				Body:       string(body),
+			}}
+		}
+	}
+
+	err := providerError(resp, body, result.Error.Status, result.Error.Message)
+	var limited *models.ErrRateLimited
+	if errors.As(err, &limited) && limited.RetryAfter == 0 {
+		for _, detail := range result.Error.Details {
+			if !strings.HasSuffix(detail.Type, "google.rpc.RetryInfo") {
+				continue
+			}
+			if delay, parseErr := time.ParseDuration(detail.RetryDelay); parseErr == nil {
+				limited.RetryAfter = delay
+			}
+		}
+	}
+	return err
+}
+
+// geminiContents converts messages to Gemini contents. System messages go in
+// the separate systemInstruction and assistant messages take the "model" role.
+func geminiContents(messages []models.Message) (string, []map[string]any) {
+	system, conversation := splitSystemMessages(messages)
+	contents := make([]map[string]any, 0, len(conversation))
+	for _, m := range conversation {
+		role := "user"
+		if m.Role == models.RoleAssistant {
+			role = "model"
+		}
+		contents = append(contents, map[string]any{
+			"role":  role,
+			"parts": []map[string]string{{"text": m.Content}},
+		})
+	}
+	return system, contents
+}
+
+// geminiResponse is a generateContent response, or a chunk of a streamed one
+type geminiResponse struct {
+	Candidates []struct {
+		Content struct {
+			Parts []struct {
+				Text    string `json:"text"`
+				Thought bool   `json:"thought"`
+			} `json:"parts"`
+		} `json:"content"`
+		FinishReason  string         `json:"finishReason"`
+		SafetyRatings []safetyRating `json:"safetyRatings"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason   string         `json:"blockReason"`
+		SafetyRatings []safetyRating `json:"safetyRatings"`
+	} `json:"promptFeedback"`
+	// UsageMetadata holds the running totals in streamed chunks
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+		ThoughtsTokenCount   int `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// usage returns the token counts of the response. Thinking tokens are billed as output.
+func (r *geminiResponse) usage() models.Usage {
+	return models.Usage{
+		InputTokens:  r.UsageMetadata.PromptTokenCount,
+		OutputTokens: r.UsageMetadata.CandidatesTokenCount + r.UsageMetadata.ThoughtsTokenCount,
+	}
+}
+
+type safetyRating struct {
+	Category    string `json:"category"`
+	Probability string `json:"probability"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// text joins the text parts of the first candidate, returning a
+// *SafetyError when the prompt or the response was blocked
+func (r *geminiResponse) text() (string, error) {
+	if r.PromptFeedback.BlockReason != "" {
+		return "", &SafetyError{Prompt: true, Reason: r.PromptFeedback.BlockReason, Categories: blockedCategories(r.PromptFeedback.SafetyRatings)}
+	}
+	if len(r.Candidates) == 0 {
+		return "", nil
+	}
+
+	candidate := r.Candidates[0]
+	var text strings.Builder
+	for _, part := range candidate.Content.Parts {
+		if !part.Thought {
+			text.WriteString(part.Text)
+		}
+	}
+
+	switch candidate.FinishReason {
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return text.String(), &SafetyError{Reason: candidate.FinishReason, Categories: blockedCategories(candidate.SafetyRatings)}
+	}
+	return text.String(), nil
+}
+
+// blockedCategories lists the harm categories that caused a block
+func blockedCategories(ratings []safetyRating) []string {
+	var categories []string
+	for _, rating := range ratings {
+		if rating.Blocked {
+			category := strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(rating.Category, "HARM_CATEGORY_"), "_", " "))
+			categories = append(categories, fmt.Sprintf("%s (%s probability)", category, strings.ToLower(rating.Probability)))
+		}
+	}
+	return categories
+}
+
+// SafetyError is returned when Gemini's safety settings block the prompt or the response
+type SafetyError struct {
+	// Prompt is set when the prompt was blocked rather than the response
+	Prompt     bool
+	Reason     string
+	Categories []string
+}
+
+func (e *SafetyError) Error() string {
+	what := "the response"
+	if e.Prompt {
+		what = "the prompt, which includes the diff and commit messages"
+	}
+	msg := fmt.Sprintf("Gemini blocked %s: %s", what, strings.ToLower(e.Reason))
+	if len(e.Categories) > 0 {
+		msg += " for " + strings.Join(e.Categories, ", ")
+	}
+	return msg
+}
+
+// Unwrap makes a safety block match models.ErrContentFiltered
+func (e *SafetyError) Unwrap() error {
+	return &models.ErrContentFiltered{APIError: models.APIError{Code: e.Reason, Message: e.Error()}}
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestGeminiSafetyBlocks(t *testing.T) {
+	tests := []struct {
+		cassette string
+		want     string
+	}{
+		{cassette: "prompt_blocked", want: "Gemini blocked the prompt, which includes the diff and commit messages: safety for dangerous content (high probability)"},
+		{cassette: "response_blocked", want: "Gemini blocked the response: safety for hate speech (medium probability)"},
+	}
+	provider := NewGeminiProvider(models.GeminiConfig{APIKey: "gemini-test", Model: "gemini-1.5-flash"})
+	for _, tt := range tests {
+		t.Run(tt.cassette, func(t *testing.T) {
+			replay(t, filepath.Join("testdata", "gemini", tt.cassette+".json"))
+			_, err := provider.GenerateResponse(context.Background(), testMessages, 0.1)
+			if err == nil || err.Error() != tt.want {
+				t.Fatalf("error = %v, want %q", err, tt.want)
+			}
+			var filtered *models.ErrContentFiltered
+			if !errors.As(err, &filtered) {
+				t.Fatalf("error = %T, want it to match *models.ErrContentFiltered", err)
+			}
+			if filtered.Code != "SAFETY" || filtered.Message != tt.want {
+				t.Errorf("ErrContentFiltered has code %q and message %q", filtered.Code, filtered.Message)
+			}
+		})
+	}
+}
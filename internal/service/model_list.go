@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+// ListModels lists the models offered by the configured provider, ignoring
+// fallbacks, sorted by name. Context lengths the provider doesn't report
+// come from the built-in registry and the [model.<name>] sections.
+func ListModels(ctx context.Context, config models.Config) ([]models.ModelInfo, error) {
+	config.Fallbacks = nil
+	provider, err := NewProviderFactory().CreateProvider(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create provider: %w", err)
+	}
+	lister, ok := provider.(models.ModelLister)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNoModelList, provider.GetName())
+	}
+
+	listed, err := lister.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range listed {
+		// Ollama reports its own, and the registry's default for unknown Ollama models isn't the model's
+		if m.ContextTokens == 0 && config.Provider != models.ProviderOllama {
+			listed[i].ContextTokens = budget.Lookup(config.Provider, m.Name, config.Capabilities).ContextTokens
+		}
+	}
+	sort.Slice(listed, func(i, j int) bool {
+		return listed[i].Name < listed[j].Name
+	})
+	return listed, nil
+}
+
+// ModelListed reports whether model is one of the listed models. Ollama
+// models match without their ":latest" tag, and Bedrock inference profiles,
+// such as "us.anthropic.claude-3-haiku-20240307-v1:0", match the model they
+// route to.
+func ModelListed(model string, listed []models.ModelInfo) bool {
+	for _, m := range listed {
+		if m.Name == model || m.Name == model+":latest" || strings.HasSuffix(model, "."+m.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON sends a request for a list and decodes the JSON response into v.
+// describe turns an unsuccessful response into an error.
+func getJSON(httpReq *http.Request, v any, describe func(*http.Response) error) error {
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return describe(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+	}
+	return nil
+}
+
+// ListModels returns the models served at the models endpoint. vLLM and
+// some gateways also report the context length of each model.
+func (c *OpenAICompatibleProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	if c.deployments {
+		return nil, fmt.Errorf("%w: %s models are deployments, which are listed in the portal", ErrNoModelList, c.name)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.withQuery(c.modelsURL()), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.setHeaders(httpReq)
+
+	var result struct {
+		Data []struct {
+			ID            string `json:"id"`
+			ContextLength int    `json:"context_length"`
+			MaxModelLen   int    `json:"max_model_len"`
+		} `json:"data"`
+	}
+	if err := getJSON(httpReq, &result, c.responseError); err != nil {
+		return nil, err
+	}
+
+	listed := make([]models.ModelInfo, len(result.Data))
+	for i, m := range result.Data {
+		listed[i] = models.ModelInfo{Name: m.ID, ContextTokens: max(m.ContextLength, m.MaxModelLen)}
+	}
+	return listed, nil
+}
+
+// ListModels returns the models available to the API key, a page at a time
+func (a *AnthropicProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	var listed []models.ModelInfo
+	afterID := ""
+	for {
+		listURL := a.baseURL + "/models?limit=1000"
+		if afterID != "" {
+			listURL += "&after_id=" + url.QueryEscape(afterID)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("x-api-key", a.apiKey)
+		httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+		var page struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+			HasMore bool   `json:"has_more"`
+			LastID  string `json:"last_id"`
+		}
+		if err := getJSON(httpReq, &page, anthropicError); err != nil {
+			return nil, err
+		}
+		for _, m := range page.Data {
+			listed = append(listed, models.ModelInfo{Name: m.ID})
+		}
+		if !page.HasMore || page.LastID == "" {
+			return listed, nil
+		}
+		afterID = page.LastID
+	}
+}
+
+// ListModels returns the models that can generate content, a page at a time
+func (g *GeminiProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	var listed []models.ModelInfo
+	pageToken := ""
+	for {
+		listURL := g.baseURL + "/models?pageSize=1000"
+		if pageToken != "" {
+			listURL += "&pageToken=" + url.QueryEscape(pageToken)
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, "GET", listURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		httpReq.Header.Set("x-goog-api-key", g.apiKey)
+
+		var page struct {
+			Models []struct {
+				Name                       string   `json:"name"`
+				InputTokenLimit            int      `json:"inputTokenLimit"`
+				SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+			} `json:"models"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := getJSON(httpReq, &page, geminiError); err != nil {
+			return nil, err
+		}
+		for _, m := range page.Models {
+			for _, method := range m.SupportedGenerationMethods {
+				if method == "generateContent" {
+					listed = append(listed, models.ModelInfo{
+						Name:          strings.TrimPrefix(m.Name, "models/"),
+						ContextTokens: m.InputTokenLimit,
+					})
+					break
+				}
+			}
+		}
+		if page.NextPageToken == "" {
+			return listed, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// ListModels returns the foundation models of the region that generate text
+func (b *BedrockProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", b.pingURL+"?byOutputModality=TEXT", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	b.signer.Sign(httpReq, nil)
+
+	var result struct {
+		ModelSummaries []struct {
+			ModelID string `json:"modelId"`
+		} `json:"modelSummaries"`
+	}
+	if err := getJSON(httpReq, &result, bedrockError); err != nil {
+		return nil, err
+	}
+
+	listed := make([]models.ModelInfo, len(result.ModelSummaries))
+	for i, m := range result.ModelSummaries {
+		listed[i] = models.ModelInfo{Name: m.ModelID}
+	}
+	return listed, nil
+}
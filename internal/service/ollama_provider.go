@@ -2,18 +2,28 @@ package service
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"time"
+	"strings"
 
+	"github.com/deleonn/gopr/internal/budget"
 	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
 )
 
+// numCtxStep rounds the context window requested from Ollama, which reloads
+// the model whenever it changes
+const numCtxStep = 2048
+
 type OllamaProvider struct {
 	baseURL string
 	model   string
+	// contextTokens caps the context window requested per prompt
+	contextTokens int
+	maxTokens     int
 }
 
 func NewOllamaProvider(config models.OllamaConfig) *OllamaProvider {
@@ -24,12 +34,19 @@ func NewOllamaProvider(config models.OllamaConfig) *OllamaProvider {
 
 	model := config.Model
 	if model == "" {
-		model = "qwen2.5-coder:14b-instruct-q8_0"
+		model = budget.DefaultModel(models.ProviderOllama)
+	}
+
+	contextTokens := config.ContextTokens
+	if contextTokens == 0 {
+		contextTokens = budget.OllamaDefaultContext
 	}
 
 	return &OllamaProvider{
-		baseURL: baseURL,
-		model:   model,
+		baseURL:       baseURL,
+		model:         model,
+		contextTokens: contextTokens,
+		maxTokens:     cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
 	}
 }
 
@@ -41,48 +58,254 @@ func (o *OllamaProvider) GetName() string {
 	return "Ollama"
 }
 
-func (o *OllamaProvider) GenerateResponse(ctx context.Context, prompt string, temperature float64) (string, error) {
+func (o *OllamaProvider) Endpoint() string {
+	return o.baseURL + "/api/chat"
+}
+
+// Ping checks that Ollama is running and that the model has been pulled
+func (o *OllamaProvider) Ping(ctx context.Context) error {
+	installed, err := o.installedModels(ctx)
+	if err != nil {
+		return err
+	}
+	if ModelListed(o.model, installed) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errModelNotFound, o.model)
+}
+
+func (o *OllamaProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	return o.generate(ctx, messages, temperature, nil)
+}
+
+// GenerateStructured passes the schema as the format, which Ollama enforces
+// while sampling
+func (o *OllamaProvider) GenerateStructured(ctx context.Context, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	return o.generate(ctx, messages, temperature, schema.Schema)
+}
+
+// generate sends a chat request, constrained to the JSON schema format when set
+func (o *OllamaProvider) generate(ctx context.Context, messages []models.Message, temperature float64, format map[string]any) (models.Response, error) {
 	requestBody := map[string]any{
-		"model":       o.model,
-		"prompt":      prompt,
-		"stream":      false,
-		"temperature": temperature,
+		"model":    o.model,
+		"messages": messages,
+		"stream":   false,
+		"options":  o.options(messages, temperature),
+	}
+	if format != nil {
+		requestBody["format"] = format
 	}
 
 	body, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
-	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.baseURL+"/api/generate", bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.Endpoint(), bytes.NewBuffer(body))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return models.Response{}, fmt.Errorf("failed to create request: %w", err)
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{
-		Timeout: 60 * time.Second,
-	}
-	resp, err := client.Do(httpReq)
+	resp, err := transport.Client().Do(httpReq)
 	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+		return models.Response{}, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return models.Response{}, ollamaError(resp)
 	}
 
 	var result map[string]any
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return models.Response{}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
 	}
 
-	response, ok := result["response"].(string)
+	message, ok := result["message"].(map[string]any)
 	if !ok {
-		return "", fmt.Errorf("invalid response format")
+		return models.Response{}, fmt.Errorf("%w: no message", errInvalidResponse)
 	}
 
-	return response, nil
+	content, ok := message["content"].(string)
+	if !ok {
+		return models.Response{}, fmt.Errorf("%w: no content", errInvalidResponse)
+	}
+
+	return models.Response{Content: content, Usage: ollamaUsage(result)}, nil
 }
 
+// ListModels returns the models installed in the Ollama instance, with their
+// size and the context length they were trained with
+func (o *OllamaProvider) ListModels(ctx context.Context) ([]models.ModelInfo, error) {
+	installed, err := o.installedModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range installed {
+		installed[i].ContextTokens = o.contextLength(ctx, installed[i].Name)
+	}
+	return installed, nil
+}
+
+// installedModels lists the installed models and their size with a single
+// request, leaving out the context lengths that take one more request each
+func (o *OllamaProvider) installedModels(ctx context.Context) ([]models.ModelInfo, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", o.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ollamaError(resp)
+	}
+
+	var result struct {
+		Models []struct {
+			Name string `json:"name"`
+			Size int64  `json:"size"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+	}
+
+	installed := make([]models.ModelInfo, len(result.Models))
+	for i, m := range result.Models {
+		installed[i] = models.ModelInfo{Name: m.Name, Size: m.Size}
+	}
+	return installed, nil
+}
+
+// contextLength asks Ollama for the context length a model was trained with,
+// returning zero when it can't tell
+func (o *OllamaProvider) contextLength(ctx context.Context, model string) int {
+	body, err := json.Marshal(map[string]string{"model": model})
+	if err != nil {
+		return 0
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.baseURL+"/api/show", bytes.NewBuffer(body))
+	if err != nil {
+		return 0
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	// The key is named after the architecture, such as "llama.context_length"
+	var result struct {
+		ModelInfo map[string]any `json:"model_info"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&result) != nil {
+		return 0
+	}
+	architecture, _ := result.ModelInfo["general.architecture"].(string)
+	length, _ := result.ModelInfo[architecture+".context_length"].(float64)
+	return int(length)
+}
+
+func (o *OllamaProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	requestBody := map[string]any{
+		"model":    o.model,
+		"messages": messages,
+		"stream":   true,
+		"options":  o.options(messages, temperature),
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", o.Endpoint(), bytes.NewBuffer(body))
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := transport.StreamClient().Do(httpReq)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Response{}, ollamaError(resp)
+	}
+
+	var text strings.Builder
+	var usage models.Usage
+	err = readNDJSON(resp.Body, func(line []byte) error {
+		var chunk struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			Error           string `json:"error"`
+			PromptEvalCount int    `json:"prompt_eval_count"`
+			EvalCount       int    `json:"eval_count"`
+			Done            bool   `json:"done"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+		}
+		if chunk.Error != "" {
+			return streamError("", chunk.Error, line)
+		}
+		// The final chunk carries the token counts
+		if chunk.PromptEvalCount > 0 || chunk.EvalCount > 0 {
+			usage = models.Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount}
+		}
+		if chunk.Message.Content != "" {
+			text.WriteString(chunk.Message.Content)
+			onToken(chunk.Message.Content)
+		}
+		if chunk.Done {
+			return errStreamDone
+		}
+		return nil
+	})
+	return models.Response{Content: text.String(), Usage: usage}, err
+}
+
+// options sets the temperature, the response size and a context window large
+// enough for the prompt and the response. Ollama's default window is 2048 or 4096 tokens
+// depending on the version, and it silently drops the start of longer prompts.
+func (o *OllamaProvider) options(messages []models.Message, temperature float64) map[string]any {
+	needed := o.maxTokens
+	for _, m := range messages {
+		needed += budget.EstimateTokens(m.Content)
+	}
+	numCtx := (needed + numCtxStep - 1) / numCtxStep * numCtxStep
+	return map[string]any{
+		"temperature": temperature,
+		"num_predict": o.maxTokens,
+		"num_ctx":     min(numCtx, o.contextTokens),
+	}
+}
+
+// ollamaUsage reads the token counts of a chat response
+func ollamaUsage(result map[string]any) models.Usage {
+	input, _ := result["prompt_eval_count"].(float64)
+	output, _ := result["eval_count"].(float64)
+	return models.Usage{InputTokens: int(input), OutputTokens: int(output)}
+}
+
+// ollamaError describes an Ollama error response, whose body is {"error": "message"}
+func ollamaError(resp *http.Response) error {
+	body := readErrorBody(resp)
+	var result struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(body, &result)
+	return providerError(resp, body, "", result.Error)
+}
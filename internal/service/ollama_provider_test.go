@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestOllamaPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		model   string
+		wantErr error
+	}{
+		{name: "pulled", model: "llama3.2"},
+		{name: "not pulled", model: "qwen2.5-coder", wantErr: errModelNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				paths = append(paths, r.URL.Path)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"models":[{"name":"llama3.2:latest","size":2019393189},{"name":"mistral:latest","size":4113301824}]}`)
+			}))
+			defer server.Close()
+
+			provider := NewOllamaProvider(models.OllamaConfig{Model: tt.model, BaseURL: server.URL})
+			if err := provider.Ping(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Ping() = %v, want %v", err, tt.wantErr)
+			}
+			if len(paths) != 1 || paths[0] != "/api/tags" {
+				t.Errorf("requested %q, want only /api/tags", paths)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package service
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/budget"
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/transport"
+)
+
+// OpenAICompatibleProvider talks to any server implementing the OpenAI chat
+// completions API, such as vLLM, LM Studio, llama.cpp or an internal gateway.
+// The OpenAI and DeepSeek providers are built on it.
+type OpenAICompatibleProvider struct {
+	name    string
+	apiKey  string
+	model   string
+	baseURL string
+	headers map[string]string
+	// maxTokens is the longest response requested
+	maxTokens int
+
+	// query is appended to every request URL
+	query url.Values
+	// pingURL is the URL checked by Ping and ListModels, baseURL/models when empty
+	pingURL string
+	// deployments is set when models are named by the user, so the models
+	// endpoint doesn't list them
+	deployments bool
+	// authHeader replaces the "Authorization: Bearer" header when set
+	authHeader string
+	// jsonObjectOnly is set for servers that accept a response_format of
+	// json_object but not json_schema, such as DeepSeek and older Azure API versions
+	jsonObjectOnly bool
+	// streamUsage asks for the token counts at the end of a stream with
+	// stream_options, which not every compatible server accepts
+	streamUsage bool
+	// mapError turns an error response into a more descriptive error than the
+	// default, when set. It returns nil to keep the default.
+	mapError func(resp *http.Response, body []byte) error
+}
+
+func NewOpenAICompatibleProvider(config models.OpenAICompatibleConfig) *OpenAICompatibleProvider {
+	return &OpenAICompatibleProvider{
+		name:      "OpenAI-compatible",
+		apiKey:    config.APIKey,
+		model:     config.Model,
+		baseURL:   strings.TrimRight(config.BaseURL, "/"),
+		headers:   config.Headers,
+		maxTokens: cmp.Or(config.MaxTokens, budget.DefaultResponseTokens),
+	}
+}
+
+func (c *OpenAICompatibleProvider) GetModel() string {
+	return c.model
+}
+
+func (c *OpenAICompatibleProvider) GetName() string {
+	return c.name
+}
+
+func (c *OpenAICompatibleProvider) Endpoint() string {
+	return c.withQuery(c.baseURL + "/chat/completions")
+}
+
+func (c *OpenAICompatibleProvider) Ping(ctx context.Context) error {
+	headers := make(map[string]string)
+	for key, value := range c.headers {
+		headers[key] = value
+	}
+	if c.apiKey != "" {
+		if c.authHeader != "" {
+			headers[c.authHeader] = c.apiKey
+		} else {
+			headers["Authorization"] = "Bearer " + c.apiKey
+		}
+	}
+	return pingEndpoint(ctx, c.withQuery(c.modelsURL()), headers)
+}
+
+// modelsURL is the URL that lists the models
+func (c *OpenAICompatibleProvider) modelsURL() string {
+	if c.pingURL != "" {
+		return c.pingURL
+	}
+	return c.baseURL + "/models"
+}
+
+// withQuery appends the provider's query parameters to rawURL
+func (c *OpenAICompatibleProvider) withQuery(rawURL string) string {
+	if len(c.query) == 0 {
+		return rawURL
+	}
+	return rawURL + "?" + c.query.Encode()
+}
+
+// setHeaders adds the extra headers and, unless running without auth, the API key
+func (c *OpenAICompatibleProvider) setHeaders(httpReq *http.Request) {
+	for key, value := range c.headers {
+		httpReq.Header.Set(key, value)
+	}
+	if c.apiKey == "" {
+		return
+	}
+	if c.authHeader != "" {
+		httpReq.Header.Set(c.authHeader, c.apiKey)
+	} else {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
+
+// responseError describes an unsuccessful response
+func (c *OpenAICompatibleProvider) responseError(resp *http.Response) error {
+	body := readErrorBody(resp)
+	if c.mapError != nil {
+		if err := c.mapError(resp, body); err != nil {
+			return err
+		}
+	}
+	code, message := openAIErrorDetail(body)
+	return providerError(resp, body, code, message)
+}
+
+// chatCompletionUsage reads the token counts of a chat completion
+func chatCompletionUsage(result map[string]any) models.Usage {
+	usage, _ := result["usage"].(map[string]any)
+	input, _ := usage["prompt_tokens"].(float64)
+	output, _ := usage["completion_tokens"].(float64)
+	return models.Usage{InputTokens: int(input), OutputTokens: int(output)}
+}
+
+// openAIErrorDetail reads the code and message of an OpenAI error body,
+// {"error": {"message": "...", "type": "...", "code": "..."}}. The code is
+// null for some errors, and compatible servers send numbers or put the
+// fields at the top level, so the type stands in for a missing code.
+func openAIErrorDetail(body []byte) (string, string) {
+	type detail struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Code    json.RawMessage `json:"code"`
+	}
+	var result struct {
+		detail
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &result) != nil {
+		return "", ""
+	}
+
+	d := result.detail
+	var nested detail
+	if json.Unmarshal(result.Error, &nested) == nil {
+		d = nested
+	} else if json.Unmarshal(result.Error, &d.Message) == nil {
+		// {"error": "message"}
+		return "", d.Message
+	}
+
+	var code string
+	if json.Unmarshal(d.Code, &code) != nil || code == "" {
+		code = d.Type
+	}
+	return code, d.Message
+}
+
+func (c *OpenAICompatibleProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	return c.generate(ctx, messages, temperature, nil)
+}
+
+// GenerateStructured asks for JSON matching the schema with a response_format
+// in strict mode. Servers that only accept JSON objects get the schema from
+// the prompt alone.
+func (c *OpenAICompatibleProvider) GenerateStructured(ctx context.Context, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	format := map[string]any{
+		"type": "json_schema",
+		"json_schema": map[string]any{
+			"name":        schema.Name,
+			"description": schema.Description,
+			"strict":      true,
+			"schema":      schema.Schema,
+		},
+	}
+	if c.jsonObjectOnly {
+		format = map[string]any{"type": "json_object"}
+	}
+	return c.generate(ctx, messages, temperature, format)
+}
+
+// generate sends a chat completion request, with responseFormat as its
+// response_format when set
+func (c *OpenAICompatibleProvider) generate(ctx context.Context, messages []models.Message, temperature float64, responseFormat map[string]any) (models.Response, error) {
+	requestBody := map[string]any{
+		"model":       c.model,
+		"messages":    messages,
+		"temperature": temperature,
+		"max_tokens":  c.maxTokens,
+	}
+	if responseFormat != nil {
+		requestBody["response_format"] = responseFormat
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint(), bytes.NewBuffer(body))
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	c.setHeaders(httpReq)
+
+	resp, err := transport.Client().Do(httpReq)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Response{}, c.responseError(resp)
+	}
+
+	var result map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return models.Response{}, fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+	}
+
+	choices, ok := result["choices"].([]any)
+	if !ok || len(choices) == 0 {
+		return models.Response{}, fmt.Errorf("%w: no choices", errInvalidResponse)
+	}
+
+	choice, ok := choices[0].(map[string]any)
+	if !ok {
+		return models.Response{}, fmt.Errorf("%w: invalid choice", errInvalidResponse)
+	}
+
+	message, ok := choice["message"].(map[string]any)
+	if !ok {
+		return models.Response{}, fmt.Errorf("%w: no message", errInvalidResponse)
+	}
+
+	content, ok := message["content"].(string)
+	if !ok {
+		if choice["finish_reason"] == "content_filter" {
+			return models.Response{}, contentFiltered("the response was withheld")
+		}
+		return models.Response{}, fmt.Errorf("%w: no content", errInvalidResponse)
+	}
+
+	return models.Response{Content: content, Usage: chatCompletionUsage(result)}, nil
+}
+
+func (c *OpenAICompatibleProvider) GenerateStream(ctx context.Context, messages []models.Message, temperature float64, onToken func(string)) (models.Response, error) {
+	requestBody := map[string]any{
+		"model":       c.model,
+		"messages":    messages,
+		"temperature": temperature,
+		"max_tokens":  c.maxTokens,
+		"stream":      true,
+	}
+	if c.streamUsage {
+		requestBody["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	body, err := json.Marshal(requestBody)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.Endpoint(), bytes.NewBuffer(body))
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	c.setHeaders(httpReq)
+
+	resp, err := transport.StreamClient().Do(httpReq)
+	if err != nil {
+		return models.Response{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return models.Response{}, c.responseError(resp)
+	}
+
+	return readChatCompletionStream(resp.Body, onToken)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+var testMessages = []models.Message{
+	{Role: models.RoleSystem, Content: "Describe the change."},
+	{Role: models.RoleUser, Content: "<git_diff>\n+added\n</git_diff>"},
+}
+
+func TestOpenAICompatibleRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiKey     string
+		wantAuth   string
+		wantHeader string
+	}{
+		{name: "with key", apiKey: "sk-test", wantAuth: "Bearer sk-test", wantHeader: "gateway-token"},
+		{name: "without key", apiKey: "", wantAuth: "", wantHeader: "gateway-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				data, _ := io.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"choices":[{"message":{"content":"Adds a line"}}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`)
+			}))
+			defer server.Close()
+
+			provider := NewOpenAICompatibleProvider(models.OpenAICompatibleConfig{
+				APIKey:  tt.apiKey,
+				Model:   "local-model",
+				BaseURL: server.URL + "/v1/",
+				Headers: map[string]string{"X-Gateway-Token": "gateway-token"},
+			})
+			response, err := provider.GenerateResponse(context.Background(), testMessages, 0.1)
+			if err != nil {
+				t.Fatalf("GenerateResponse: %v", err)
+			}
+
+			if got.URL.Path != "/v1/chat/completions" {
+				t.Errorf("path = %q, want /v1/chat/completions", got.URL.Path)
+			}
+			if auth, ok := got.Header["Authorization"]; tt.wantAuth == "" && ok {
+				t.Errorf("Authorization header sent without a key: %q", auth)
+			} else if tt.wantAuth != "" && got.Header.Get("Authorization") != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got.Header.Get("Authorization"), tt.wantAuth)
+			}
+			if h := got.Header.Get("X-Gateway-Token"); h != tt.wantHeader {
+				t.Errorf("X-Gateway-Token = %q, want %q", h, tt.wantHeader)
+			}
+			if body["model"] != "local-model" {
+				t.Errorf("model = %v, want local-model", body["model"])
+			}
+			want := models.Response{Content: "Adds a line", Usage: models.Usage{InputTokens: 12, OutputTokens: 3}}
+			if response != want {
+				t.Errorf("response = %+v, want %+v", response, want)
+			}
+		})
+	}
+}
+
+func TestOpenAICompatibleStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept := r.Header.Get("Accept"); accept != "text/event-stream" {
+			t.Errorf("Accept = %q, want text/event-stream", accept)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, ": keep-alive\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"role\":\"assistant\"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Adds \"}}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"a line\"},\"finish_reason\":\"stop\"}]}\n\n")
+		io.WriteString(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":3}}\n\n")
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := NewOpenAICompatibleProvider(models.OpenAICompatibleConfig{Model: "local-model", BaseURL: server.URL})
+	var tokens []string
+	response, err := provider.GenerateStream(context.Background(), testMessages, 0.1, func(token string) {
+		tokens = append(tokens, token)
+	})
+	if err != nil {
+		t.Fatalf("GenerateStream: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0] != "Adds " || tokens[1] != "a line" {
+		t.Errorf("tokens = %q, want [\"Adds \" \"a line\"]", tokens)
+	}
+	want := models.Response{Content: "Adds a line", Usage: models.Usage{InputTokens: 12, OutputTokens: 3}}
+	if response != want {
+		t.Errorf("response = %+v, want %+v", response, want)
+	}
+}
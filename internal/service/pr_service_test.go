@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deleonn/gopr/internal/models"
+	"github.com/deleonn/gopr/internal/usage"
+)
+
+// testRepo makes a repository the working directory, on a branch one commit
+// ahead of main, and keeps the usage ledger out of the user's config. The
+// config directory is under XDG_CONFIG_HOME on Linux, HOME on macOS and
+// AppData on Windows, so all three point to an empty directory.
+func testRepo(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	t.Setenv("AppData", home)
+	if path, err := usage.LedgerPath(); err != nil || !strings.HasPrefix(path, home) {
+		t.Fatalf("usage ledger at %q (%v), want it under %s", path, err, home)
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", os.DevNull)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "gopr")
+	t.Setenv("GIT_AUTHOR_EMAIL", "gopr@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "gopr")
+	t.Setenv("GIT_COMMITTER_EMAIL", "gopr@example.com")
+
+	git := func(args ...string) {
+		t.Helper()
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	writeFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q", "-b", "main")
+	writeFile("README.md", "# Example\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "Initial commit")
+	git("checkout", "-q", "-b", "feature")
+	writeFile("README.md", "# Example\n\nUsage notes.\n")
+	git("commit", "-q", "-am", "Add usage notes to the README")
+}
+
+// newTestService creates a service comparing with main, whose waits between
+// attempts are recorded instead of slept
+func newTestService(t *testing.T, config models.Config) (*PRService, *[]time.Duration) {
+	t.Helper()
+	s, err := NewPRService(config, "main")
+	if err != nil {
+		t.Fatalf("NewPRService: %v", err)
+	}
+	var waits []time.Duration
+	s.sleep = func(d time.Duration) { waits = append(waits, d) }
+	return s, &waits
+}
+
+// lastEntry returns the last run recorded in the usage ledger
+func lastEntry(t *testing.T) usage.Entry {
+	t.Helper()
+	path, err := usage.LedgerPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := usage.ReadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("no run recorded in the usage ledger")
+	}
+	return entries[len(entries)-1]
+}
+
+func TestGenerateRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		fake models.FakeConfig
+		// errAs is a pointer to the error type expected, nil for success
+		errAs        any
+		wantAttempts int
+		wantWaits    []time.Duration
+		wantGeneric  bool
+	}{
+		{name: "success", wantAttempts: 1},
+		{name: "rate limit", fake: models.FakeConfig{Errors: []string{"rate_limit"}}, wantAttempts: 2, wantWaits: []time.Duration{time.Second}},
+		{name: "network failure", fake: models.FakeConfig{Errors: []string{"network"}}, wantAttempts: 2, wantWaits: []time.Duration{time.Second}},
+		{name: "invalid response", fake: models.FakeConfig{Errors: []string{"invalid"}}, wantAttempts: 2, wantWaits: []time.Duration{time.Second}},
+		{
+			name:         "server errors use up the attempts",
+			fake:         models.FakeConfig{Errors: []string{"server", "server", "server"}},
+			errAs:        new(*models.ErrServer),
+			wantAttempts: 3,
+			wantWaits:    []time.Duration{time.Second, 2 * time.Second},
+		},
+		{name: "auth is not retried", fake: models.FakeConfig{Errors: []string{"auth"}}, errAs: new(*models.ErrAuth), wantAttempts: 1},
+		{name: "context too long is not retried", fake: models.FakeConfig{Errors: []string{"context_too_long"}}, errAs: new(*models.ErrContextTooLong), wantAttempts: 1},
+		{name: "generic response", fake: models.FakeConfig{Generic: 1}, wantAttempts: 2, wantWaits: []time.Duration{time.Second}},
+		{
+			name:         "generic responses are kept on the last attempt",
+			fake:         models.FakeConfig{Generic: 3},
+			wantAttempts: 3,
+			wantWaits:    []time.Duration{time.Second, 2 * time.Second},
+			wantGeneric:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRepo(t)
+			s, waits := newTestService(t, models.Config{Provider: models.ProviderFake, Fake: tt.fake})
+
+			description, err := s.GeneratePRDescriptionFromBranch(false)
+			switch {
+			case tt.errAs == nil && err != nil:
+				t.Fatalf("GeneratePRDescriptionFromBranch: %v", err)
+			case tt.errAs != nil && !errors.As(err, tt.errAs):
+				t.Fatalf("error = %T %v, want %T", err, err, tt.errAs)
+			case tt.errAs == nil && (description == fakeGeneric) != tt.wantGeneric:
+				t.Errorf("description = %q, want generic %v", description, tt.wantGeneric)
+			}
+			if !slices.Equal(*waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+			if entry := lastEntry(t); entry.Attempts != tt.wantAttempts {
+				t.Errorf("recorded %d attempts, want %d", entry.Attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// limitedProvider answers its first request with a rate limit that asks to
+// wait retryAfter
+type limitedProvider struct {
+	models.LLMProvider
+	retryAfter time.Duration
+	limited    bool
+}
+
+func (l *limitedProvider) GenerateResponse(ctx context.Context, messages []models.Message, temperature float64) (models.Response, error) {
+	if !l.limited {
+		l.limited = true
+		return models.Response{}, &models.ErrRateLimited{APIError: models.APIError{StatusCode: 429}, RetryAfter: l.retryAfter}
+	}
+	return l.LLMProvider.GenerateResponse(ctx, messages, temperature)
+}
+
+func TestGenerateRetryAfter(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryAfter   time.Duration
+		wantErr      bool
+		wantAttempts int
+		wantWaits    []time.Duration
+	}{
+		{name: "waits as asked", retryAfter: 5 * time.Second, wantAttempts: 2, wantWaits: []time.Duration{5 * time.Second}},
+		{name: "shorter than the backoff", retryAfter: 500 * time.Millisecond, wantAttempts: 2, wantWaits: []time.Duration{time.Second}},
+		{name: "too long to wait", retryAfter: 2 * time.Hour, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testRepo(t)
+			s, waits := newTestService(t, models.Config{Provider: models.ProviderFake})
+			s.provider = &limitedProvider{LLMProvider: s.provider, retryAfter: tt.retryAfter}
+
+			_, err := s.GeneratePRDescriptionFromBranch(false)
+			var limited *models.ErrRateLimited
+			if tt.wantErr != (err != nil) || (tt.wantErr && !errors.As(err, &limited)) {
+				t.Fatalf("error = %v, want a rate limit error %v", err, tt.wantErr)
+			}
+			if !slices.Equal(*waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+			if entry := lastEntry(t); entry.Attempts != tt.wantAttempts {
+				t.Errorf("recorded %d attempts, want %d", entry.Attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestGenerateFallsBackOnNetworkFailure(t *testing.T) {
+	cassette, err := filepath.Abs("testdata/openai_compatible/ok.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay(t, cassette)
+	testRepo(t)
+	s, waits := newTestService(t, models.Config{
+		Provider: models.ProviderFake,
+		Fake:     models.FakeConfig{Errors: []string{"network"}},
+		Fallbacks: []models.Config{
+			{Provider: models.ProviderOpenAICompatible, Model: "local-model", BaseURL: "http://localhost:8080/v1"},
+		},
+	})
+
+	description, err := s.GeneratePRDescriptionFromBranch(false)
+	if err != nil {
+		t.Fatalf("GeneratePRDescriptionFromBranch: %v", err)
+	}
+	if !strings.Contains(description, "<!-- Generated by gopr with OpenAI-compatible, model local-model -->") {
+		t.Errorf("description doesn't name the fallback that answered:\n%s", description)
+	}
+	if len(*waits) != 0 {
+		t.Errorf("waited %v before falling back", *waits)
+	}
+	if entry := lastEntry(t); entry.Model != "local-model" || entry.Attempts != 1 {
+		t.Errorf("recorded model %q with %d attempts, want local-model with 1", entry.Model, entry.Attempts)
+	}
+}
@@ -1,8 +1,12 @@
 package service
 
 import (
+	"cmp"
 	"fmt"
+	"os/exec"
 
+	"github.com/deleonn/gopr/internal/aws"
+	"github.com/deleonn/gopr/internal/budget"
 	"github.com/deleonn/gopr/internal/models"
 )
 
@@ -13,13 +17,55 @@ func NewProviderFactory() *ProviderFactory {
 	return &ProviderFactory{}
 }
 
-// CreateProvider creates a new LLM provider based on the configuration
+// CreateProvider creates a new LLM provider based on the configuration,
+// wrapped in a FallbackProvider when fallbacks are configured
 func (f *ProviderFactory) CreateProvider(config models.Config) (models.LLMProvider, error) {
+	if len(config.Fallbacks) > 0 {
+		primary := config
+		primary.Fallbacks = nil
+		chain := []models.Config{primary}
+		for _, fallback := range config.Fallbacks {
+			// Model limits apply to the whole chain
+			fallback.Capabilities = config.Capabilities
+			chain = append(chain, fallback)
+		}
+		return NewFallbackProvider(f, chain), nil
+	}
+
+	// The response size is resolved like PRService does, so that what is
+	// requested matches what the prompt budget leaves room for
+	maxTokens := budget.ResponseTokens(budget.Lookup(config.Provider, cmp.Or(config.Model, budget.DefaultModel(config.Provider)), config.Capabilities))
+
+	if path, ok := config.Provider.ExecPath(); ok {
+		resolved, err := exec.LookPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("plugin %s not found: %w", path, err)
+		}
+		execConfig := models.ExecConfig{
+			Path:      resolved,
+			Model:     config.Model,
+			APIKey:    config.APIKey,
+			BaseURL:   config.BaseURL,
+			MaxTokens: maxTokens,
+		}
+		return NewExecProvider(execConfig), nil
+	}
+
 	switch config.Provider {
+	case models.ProviderFake:
+		fakeConfig := config.Fake
+		if config.Model != "" {
+			fakeConfig.Model = config.Model
+		}
+		return NewFakeProvider(fakeConfig), nil
+
 	case models.ProviderOllama:
+		model := cmp.Or(config.Model, budget.DefaultModel(models.ProviderOllama))
 		ollamaConfig := models.OllamaConfig{
-			BaseURL: config.BaseURL,
-			Model:   config.Model,
+			BaseURL:       config.BaseURL,
+			Model:         model,
+			ContextTokens: budget.Lookup(models.ProviderOllama, model, config.Capabilities).ContextTokens,
+			MaxTokens:     maxTokens,
 		}
 		return NewOllamaProvider(ollamaConfig), nil
 
@@ -28,8 +74,11 @@ func (f *ProviderFactory) CreateProvider(config models.Config) (models.LLMProvid
 			return nil, fmt.Errorf("API key is required for OpenAI provider")
 		}
 		openAIConfig := models.OpenAIConfig{
-			APIKey: config.APIKey,
-			Model:  config.Model,
+			APIKey:    config.APIKey,
+			Model:     config.Model,
+			BaseURL:   config.BaseURL,
+			Headers:   config.Headers,
+			MaxTokens: maxTokens,
 		}
 		return NewOpenAIProvider(openAIConfig), nil
 
@@ -38,8 +87,9 @@ func (f *ProviderFactory) CreateProvider(config models.Config) (models.LLMProvid
 			return nil, fmt.Errorf("API key is required for Anthropic provider")
 		}
 		anthropicConfig := models.AnthropicConfig{
-			APIKey: config.APIKey,
-			Model:  config.Model,
+			APIKey:    config.APIKey,
+			Model:     config.Model,
+			MaxTokens: maxTokens,
 		}
 		return NewAnthropicProvider(anthropicConfig), nil
 
@@ -48,11 +98,88 @@ func (f *ProviderFactory) CreateProvider(config models.Config) (models.LLMProvid
 			return nil, fmt.Errorf("API key is required for DeepSeek provider")
 		}
 		deepSeekConfig := models.DeepSeekConfig{
-			APIKey: config.APIKey,
-			Model:  config.Model,
+			APIKey:    config.APIKey,
+			Model:     config.Model,
+			BaseURL:   config.BaseURL,
+			Headers:   config.Headers,
+			MaxTokens: maxTokens,
 		}
 		return NewDeepSeekProvider(deepSeekConfig), nil
 
+	case models.ProviderOpenAICompatible:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("base URL is required for OpenAI-compatible provider")
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("model is required for OpenAI-compatible provider")
+		}
+		compatibleConfig := models.OpenAICompatibleConfig{
+			APIKey:    config.APIKey,
+			Model:     config.Model,
+			BaseURL:   config.BaseURL,
+			Headers:   config.Headers,
+			MaxTokens: maxTokens,
+		}
+		return NewOpenAICompatibleProvider(compatibleConfig), nil
+
+	case models.ProviderAzureOpenAI:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("API key is required for Azure OpenAI provider")
+		}
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("base URL is required for Azure OpenAI provider, set it to the resource endpoint")
+		}
+		if config.Model == "" {
+			return nil, fmt.Errorf("model is required for Azure OpenAI provider, set it to the deployment name")
+		}
+		azureConfig := models.AzureOpenAIConfig{
+			APIKey:     config.APIKey,
+			Endpoint:   config.BaseURL,
+			Deployment: config.Model,
+			APIVersion: config.APIVersion,
+			Headers:    config.Headers,
+			MaxTokens:  maxTokens,
+		}
+		return NewAzureOpenAIProvider(azureConfig), nil
+
+	case models.ProviderGemini:
+		if config.APIKey == "" {
+			return nil, fmt.Errorf("API key is required for Gemini provider")
+		}
+		geminiConfig := models.GeminiConfig{
+			APIKey:    config.APIKey,
+			Model:     config.Model,
+			BaseURL:   config.BaseURL,
+			MaxTokens: maxTokens,
+		}
+		return NewGeminiProvider(geminiConfig), nil
+
+	case models.ProviderBedrock:
+		profile := aws.Profile()
+		credentials, err := aws.LoadCredentials(profile)
+		if err != nil {
+			return nil, err
+		}
+		region := config.Region
+		if region == "" {
+			if region, err = aws.LoadRegion(profile); err != nil {
+				return nil, err
+			}
+		}
+		if region == "" {
+			return nil, fmt.Errorf("region is required for Bedrock provider, set region or AWS_REGION")
+		}
+		bedrockConfig := models.BedrockConfig{
+			Model:           config.Model,
+			Region:          region,
+			BaseURL:         config.BaseURL,
+			AccessKeyID:     credentials.AccessKeyID,
+			SecretAccessKey: credentials.SecretAccessKey,
+			SessionToken:    credentials.SessionToken,
+			MaxTokens:       maxTokens,
+		}
+		return NewBedrockProvider(bedrockConfig), nil
+
 	default:
 		return nil, fmt.Errorf("unsupported provider: %s", config.Provider)
 	}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+func TestCreateProviderMaxTokens(t *testing.T) {
+	overrides := map[string]models.Capabilities{"small-model": {MaxOutputTokens: 1024}}
+	tests := []struct {
+		name     string
+		config   models.Config
+		response string
+		// maxTokens reads the response size from the request body
+		maxTokens func(body map[string]any) any
+		want      float64
+	}{
+		{
+			name:      "openai-compatible default",
+			config:    models.Config{Provider: models.ProviderOpenAICompatible, Model: "local-model"},
+			response:  `{"choices":[{"message":{"content":"Adds a line"}}]}`,
+			maxTokens: func(body map[string]any) any { return body["max_tokens"] },
+			want:      4000,
+		},
+		{
+			name:      "openai-compatible override",
+			config:    models.Config{Provider: models.ProviderOpenAICompatible, Model: "small-model"},
+			response:  `{"choices":[{"message":{"content":"Adds a line"}}]}`,
+			maxTokens: func(body map[string]any) any { return body["max_tokens"] },
+			want:      1024,
+		},
+		{
+			name:     "gemini",
+			config:   models.Config{Provider: models.ProviderGemini, Model: "small-model", APIKey: "gemini-test"},
+			response: `{"candidates":[{"content":{"parts":[{"text":"Adds a line"}]},"finishReason":"STOP"}]}`,
+			maxTokens: func(body map[string]any) any {
+				generationConfig, _ := body["generationConfig"].(map[string]any)
+				return generationConfig["maxOutputTokens"]
+			},
+			want: 1024,
+		},
+		{
+			name:     "ollama",
+			config:   models.Config{Provider: models.ProviderOllama, Model: "small-model"},
+			response: `{"message":{"role":"assistant","content":"Adds a line"},"done":true}`,
+			maxTokens: func(body map[string]any) any {
+				options, _ := body["options"].(map[string]any)
+				return options["num_predict"]
+			},
+			want: 1024,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&body)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.response)
+			}))
+			defer server.Close()
+
+			config := tt.config
+			config.BaseURL = server.URL
+			config.Capabilities = overrides
+			provider, err := NewProviderFactory().CreateProvider(config)
+			if err != nil {
+				t.Fatalf("CreateProvider: %v", err)
+			}
+			if _, err := provider.GenerateResponse(context.Background(), testMessages, 0.1); err != nil {
+				t.Fatalf("GenerateResponse: %v", err)
+			}
+			if got := tt.maxTokens(body); got != tt.want {
+				t.Errorf("requested %v output tokens, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"strings"
+)
+
+// redactedPlaceholder replaces secrets in error messages
+const redactedPlaceholder = "[REDACTED]"
+
+// redactedError hides secrets in the message of an error while keeping it
+// unwrappable, so callers can still inspect the underlying error
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError returns err with every occurrence of the secrets removed from its message
+func redactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	msg := redactString(err.Error(), secrets...)
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{msg: msg, err: err}
+}
+
+// redactString removes every occurrence of the secrets from s
+func redactString(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+		}
+	}
+	return s
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// maxStreamLine bounds the size of a single line of a streamed response
+const maxStreamLine = 1024 * 1024
+
+// errStreamDone is returned by the callbacks of readSSE and readNDJSON at
+// the event that ends the stream, such as Anthropic's message_stop
+var errStreamDone = errors.New("end of stream")
+
+// errStreamCut is returned when a stream ends before its last event, as
+// when the connection is dropped halfway through a response
+var errStreamCut = fmt.Errorf("%w: the stream ended before the response was complete", errInvalidResponse)
+
+// readSSE calls onEvent with the event type and data of each server-sent
+// event until the data is [DONE], onEvent returns errStreamDone or fails. A
+// stream that ends before that returns errStreamCut.
+func readSSE(r io.Reader, onEvent func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+
+	var event string
+	var data []string
+	dispatch := func() error {
+		defer func() { event, data = "", nil }()
+		if len(data) == 0 {
+			return nil
+		}
+		return onEvent(event, strings.Join(data, "\n"))
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := dispatch(); err != nil {
+				return streamEnd(err)
+			}
+		case strings.HasPrefix(line, ":"):
+			// Comment, used as keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			value := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+			if value == "[DONE]" {
+				return nil
+			}
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := dispatch(); err != nil {
+		return streamEnd(err)
+	}
+	return errStreamCut
+}
+
+// readNDJSON calls onLine with each non-empty line of a newline-delimited
+// JSON stream until onLine returns errStreamDone or fails. A stream that
+// ends before that returns errStreamCut.
+func readNDJSON(r io.Reader, onLine func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLine)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		if err := onLine(line); err != nil {
+			return streamEnd(err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errStreamCut
+}
+
+// streamEnd turns the error of a stream callback into the result of the stream
+func streamEnd(err error) error {
+	if errors.Is(err, errStreamDone) {
+		return nil
+	}
+	return err
+}
+
+// readChatCompletionStream collects the text of an OpenAI-style chat
+// completion stream, and its token counts when the server sends them
+func readChatCompletionStream(r io.Reader, onToken func(string)) (models.Response, error) {
+	var text strings.Builder
+	var usage models.Usage
+	err := readSSE(r, func(event, data string) error {
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+				FinishReason string `json:"finish_reason"`
+			} `json:"choices"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+			// Usage is sent in a last chunk without choices
+			Usage *struct {
+				PromptTokens     int `json:"prompt_tokens"`
+				CompletionTokens int `json:"completion_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("%w: failed to decode response: %v", errInvalidResponse, err)
+		}
+		if chunk.Error != nil {
+			code, message := openAIErrorDetail([]byte(data))
+			return streamError(code, message, []byte(data))
+		}
+		if chunk.Usage != nil {
+			usage = models.Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				text.WriteString(choice.Delta.Content)
+				onToken(choice.Delta.Content)
+			}
+			if choice.FinishReason == "content_filter" {
+				return contentFiltered("the response was cut off")
+			}
+		}
+		return nil
+	})
+	return models.Response{Content: text.String(), Usage: usage}, err
+}
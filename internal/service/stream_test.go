@@ -0,0 +1,101 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadSSE(t *testing.T) {
+	failed := errors.New("callback failed")
+	tests := []struct {
+		name       string
+		stream     string
+		wantEvents []string
+		wantErr    error
+	}{
+		{
+			name:       "done marker",
+			stream:     ": keep-alive\n\ndata: {\"a\":1}\n\ndata: {\"a\":2}\ndata: {\"b\":3}\n\ndata: [DONE]\n\ndata: ignored\n\n",
+			wantEvents: []string{`{"a":1}`, "{\"a\":2}\n{\"b\":3}"},
+		},
+		{
+			name:       "end event",
+			stream:     "event: message_start\ndata: {}\n\nevent: message_stop\ndata: {\"type\":\"message_stop\"}\n\nevent: ping\ndata: {}\n\n",
+			wantEvents: []string{"message_start {}", `message_stop {"type":"message_stop"}`},
+		},
+		{
+			name:       "end event without a blank line",
+			stream:     "event: message_stop\ndata: {\"type\":\"message_stop\"}",
+			wantEvents: []string{`message_stop {"type":"message_stop"}`},
+		},
+		{
+			name:       "cut off",
+			stream:     "data: {\"a\":1}\n\ndata: {\"a\":2}\n",
+			wantEvents: []string{`{"a":1}`, `{"a":2}`},
+			wantErr:    errInvalidResponse,
+		},
+		{
+			name:    "empty",
+			wantErr: errInvalidResponse,
+		},
+		{
+			name:       "callback error",
+			stream:     "event: error\ndata: {}\n\ndata: [DONE]\n\n",
+			wantEvents: []string{"error {}"},
+			wantErr:    failed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []string
+			err := readSSE(strings.NewReader(tt.stream), func(event, data string) error {
+				events = append(events, strings.TrimSpace(event+" "+data))
+				switch event {
+				case "message_stop":
+					return errStreamDone
+				case "error":
+					return failed
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !slices.Equal(events, tt.wantEvents) {
+				t.Errorf("events = %q, want %q", events, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestReadNDJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    string
+		wantLines int
+		wantErr   error
+	}{
+		{name: "done", stream: "{\"done\":false}\n\n{\"done\":true}\n", wantLines: 2},
+		{name: "cut off", stream: "{\"done\":false}\n{\"done\":false}\n", wantLines: 2, wantErr: errInvalidResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := 0
+			err := readNDJSON(strings.NewReader(tt.stream), func(line []byte) error {
+				lines++
+				if strings.Contains(string(line), `"done":true`) {
+					return errStreamDone
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if lines != tt.wantLines {
+				t.Errorf("read %d lines, want %d", lines, tt.wantLines)
+			}
+		})
+	}
+}
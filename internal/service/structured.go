@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// generateStructured asks provider for JSON matching schema, through its
+// own mechanism when it has one. Other providers only have the prompt, which
+// describes the fields, to go by.
+func generateStructured(ctx context.Context, provider models.LLMProvider, messages []models.Message, temperature float64, schema models.Schema) (models.Response, error) {
+	if structured, ok := provider.(models.StructuredGenerator); ok {
+		return structured.GenerateStructured(ctx, messages, temperature, schema)
+	}
+	return provider.GenerateResponse(ctx, messages, temperature)
+}
+
+// decodeDescription decodes a structured response into a PR description. A
+// response that isn't the expected JSON, or leaves out the summary or the
+// changes, is invalid.
+func decodeDescription(content string) (models.PRDescription, error) {
+	// Models that only have the prompt to go by often wrap the JSON in a code block
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+	}
+
+	var description models.PRDescription
+	if err := json.Unmarshal([]byte(content), &description); err != nil {
+		return models.PRDescription{}, fmt.Errorf("%w: failed to decode the description: %v", errInvalidResponse, err)
+	}
+	if strings.TrimSpace(description.Summary) == "" || len(description.Changes) == 0 {
+		return models.PRDescription{}, fmt.Errorf("%w: the description has no summary or changes", errInvalidResponse)
+	}
+	return description, nil
+}
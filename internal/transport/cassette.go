@@ -0,0 +1,250 @@
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Cassette is a recording of HTTP interactions, saved as JSON so that
+// provider requests can be replayed without the network
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got, with credentials removed
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	// Body holds a text body, BodyBase64 a binary one such as a Bedrock event stream
+	Body       string `json:"body,omitempty"`
+	BodyBase64 []byte `json:"body_base64,omitempty"`
+}
+
+// LoadCassette reads a cassette file
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var c Cassette
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the cassette to path
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// recorder sends requests on and saves every interaction to a cassette
+// file, rewriting it as each response body is closed
+type recorder struct {
+	next     http.RoundTripper
+	path     string
+	secrets  []string
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+// NewRecorder returns a transport that sends requests through next and
+// records them to the cassette at path, replacing its contents. Auth headers
+// and the secrets are redacted before anything is written.
+func NewRecorder(path string, next http.RoundTripper, secrets []string) http.RoundTripper {
+	return &recorder{next: next, path: path, secrets: secrets}
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	interaction := Interaction{
+		Request: RecordedRequest{
+			Method: req.Method,
+			URL:    redactSecrets(req.URL.String(), r.secrets),
+			Header: r.redactHeader(req.Header),
+			Body:   redactSecrets(string(body), r.secrets),
+		},
+		Response: RecordedResponse{
+			StatusCode: resp.StatusCode,
+			Header:     r.redactHeader(resp.Header),
+		},
+	}
+	// The body is recorded as it is read, so streams still arrive as they are generated
+	resp.Body = &recordedBody{ReadCloser: resp.Body, onClose: func(data []byte) {
+		if utf8.Valid(data) {
+			interaction.Response.Body = redactSecrets(string(data), r.secrets)
+		} else {
+			interaction.Response.BodyBase64 = data
+		}
+		r.save(interaction)
+	}}
+	return resp, nil
+}
+
+// save adds an interaction and rewrites the cassette. A cassette that can't
+// be written must not fail the run it records, so errors only get a warning.
+func (r *recorder) save(interaction Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	if err := r.cassette.Save(r.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// redactHeader copies a header with the credentials and secrets removed
+func (r *recorder) redactHeader(header http.Header) http.Header {
+	redactedHeader := make(http.Header, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			if secretHeaders[http.CanonicalHeaderKey(name)] {
+				value = redacted
+			}
+			redactedHeader.Add(name, redactSecrets(value, r.secrets))
+		}
+	}
+	return redactedHeader
+}
+
+// recordedBody keeps the whole body and hands it to onClose
+type recordedBody struct {
+	io.ReadCloser
+	buf     bytes.Buffer
+	onClose func([]byte)
+	closed  bool
+}
+
+func (b *recordedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordedBody) Close() error {
+	if !b.closed {
+		b.closed = true
+		// Keep what the caller didn't read, so the recording is complete
+		io.Copy(&b.buf, b.ReadCloser)
+		b.onClose(b.buf.Bytes())
+	}
+	return b.ReadCloser.Close()
+}
+
+// replayer answers requests from a cassette without the network
+type replayer struct {
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+	secrets  []string
+}
+
+// NewReplayer returns a transport that answers every request from the
+// cassette at path. Each recorded interaction is used once, in order, by the
+// first request with the same method and URL; a request with none left fails.
+func NewReplayer(path string, secrets []string) (http.RoundTripper, error) {
+	c, err := LoadCassette(path)
+	if err != nil {
+		return nil, err
+	}
+	return &replayer{cassette: c, used: make([]bool, len(c.Interactions)), secrets: secrets}, nil
+}
+
+func (r *replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	// Recorded URLs are redacted, so compare the redacted form
+	target := redactSecrets(req.URL.String(), r.secrets)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != req.Method || interaction.Request.URL != target {
+			continue
+		}
+		r.used[i] = true
+
+		recorded := interaction.Response
+		body := recorded.BodyBase64
+		if body == nil {
+			body = []byte(recorded.Body)
+		}
+		header := recorded.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+			StatusCode:    recorded.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("no recorded response left for %s %s", req.Method, target)
+}
+
+// readRequestBody reads the body of a request, returning a copy of the
+// request whose body can still be sent
+func readRequestBody(req *http.Request) ([]byte, *http.Request, error) {
+	if req.Body == nil {
+		return nil, req, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, req, nil
+}
+
+// redactSecrets replaces every occurrence of the secrets in s
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDebugBody bounds how much of a body is printed
+const maxDebugBody = 64 * 1024
+
+// redacted replaces secrets in the debug output
+const redacted = "[REDACTED]"
+
+// secretHeaders carry credentials and are never printed
+var secretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"X-Api-Key":            true,
+	"Api-Key":              true,
+	"X-Goog-Api-Key":       true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+	"Set-Cookie":           true,
+}
+
+// debugTransport prints every request and response, with credentials
+// removed. Response bodies are printed once they have been read, so
+// streamed responses appear at the end of the stream.
+type debugTransport struct {
+	next    http.RoundTripper
+	w       io.Writer
+	secrets []string
+	mu      sync.Mutex
+}
+
+func (d *debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, req, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--> %s %s\n", req.Method, req.URL)
+	d.writeHeaders(&out, req.Header)
+	out.WriteString("\n")
+	d.writeBody(&out, req.Header.Get("Content-Type"), body, len(body))
+	d.print(out.String())
+
+	start := time.Now()
+	resp, err := d.next.RoundTrip(req)
+	if err != nil {
+		d.print(fmt.Sprintf("<-- %s %s failed after %s: %v\n\n", req.Method, req.URL, time.Since(start).Round(time.Millisecond), err))
+		return nil, err
+	}
+
+	out.Reset()
+	fmt.Fprintf(&out, "<-- %s %s %s (%s)\n", resp.Status, req.Method, req.URL, time.Since(start).Round(time.Millisecond))
+	d.writeHeaders(&out, resp.Header)
+	out.WriteString("\n")
+	d.print(out.String())
+
+	resp.Body = &debugBody{ReadCloser: resp.Body, d: d, request: req.Method + " " + req.URL.String(), contentType: resp.Header.Get("Content-Type")}
+	return resp, nil
+}
+
+// writeHeaders writes the headers sorted by name, with credentials removed
+func (d *debugTransport) writeHeaders(out *strings.Builder, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if secretHeaders[http.CanonicalHeaderKey(name)] {
+				value = redacted
+			}
+			fmt.Fprintf(out, "%s: %s\n", name, value)
+		}
+	}
+}
+
+// writeBody writes the start of a text body of size bytes, or only the size
+// of a binary one
+func (d *debugTransport) writeBody(out *strings.Builder, contentType string, body []byte, size int) {
+	if size == 0 {
+		return
+	}
+	if !isText(contentType) {
+		fmt.Fprintf(out, "(%d bytes of %s)\n\n", size, contentType)
+		return
+	}
+	out.Write(body[:min(len(body), maxDebugBody)])
+	if size > maxDebugBody {
+		fmt.Fprintf(out, "\n... (%d more bytes)", size-maxDebugBody)
+	}
+	out.WriteString("\n\n")
+}
+
+// print writes to the debug output with the secrets removed
+func (d *debugTransport) print(s string) {
+	s = redactSecrets(s, d.secrets)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	io.WriteString(d.w, s)
+}
+
+// debugBody keeps what is read of a response body and prints it on Close
+type debugBody struct {
+	io.ReadCloser
+	d           *debugTransport
+	request     string
+	contentType string
+	// buf keeps the start of the body, size counts all of it
+	buf    bytes.Buffer
+	size   int
+	closed bool
+}
+
+func (b *debugBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxDebugBody - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	b.size += n
+	return n, err
+}
+
+func (b *debugBody) Close() error {
+	if !b.closed && b.size > 0 {
+		b.closed = true
+		var out strings.Builder
+		fmt.Fprintf(&out, "<-- body of %s\n", b.request)
+		b.d.writeBody(&out, b.contentType, b.buf.Bytes(), b.size)
+		b.d.print(out.String())
+	}
+	return b.ReadCloser.Close()
+}
+
+// isText reports whether a content type can be printed as is
+func isText(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "text/") ||
+		strings.Contains(contentType, "json") || strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "x-www-form-urlencoded")
+}
@@ -0,0 +1,153 @@
+// Package transport holds the HTTP transport shared by every provider, so
+// that timeouts, proxies and trusted certificates are set in one place and
+// connections to a provider are reused across requests.
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Options configure the shared transport
+type Options struct {
+	// Timeout bounds a request, or the wait for the response headers of a stream
+	Timeout time.Duration
+	// ConnectTimeout bounds connecting, TLS handshake included
+	ConnectTimeout time.Duration
+	// CABundle is a PEM file of certificate authorities trusted on top of
+	// the system ones, such as the one of a TLS-intercepting proxy
+	CABundle string
+	// Debug receives every request and response when set
+	Debug io.Writer
+	// Record is a cassette file that every interaction is saved to, when set
+	Record string
+	// Replay is a cassette file that answers every request instead of the
+	// network, when set
+	Replay string
+	// Secrets are removed from the debug output and recordings, on top of
+	// the auth headers
+	Secrets []string
+}
+
+// Defaults used until Configure is called
+const (
+	defaultTimeout        = 60 * time.Second
+	defaultConnectTimeout = 10 * time.Second
+)
+
+var (
+	mu      sync.Mutex
+	shared  http.RoundTripper
+	timeout = defaultTimeout
+)
+
+// Configure replaces the shared transport. It is meant to be called once,
+// before any provider sends a request. Zero timeouts keep the defaults.
+func Configure(opts Options) error {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.ConnectTimeout == 0 {
+		opts.ConnectTimeout = defaultConnectTimeout
+	}
+
+	if opts.Record != "" && opts.Replay != "" {
+		return fmt.Errorf("can't record and replay at the same time")
+	}
+
+	var rt http.RoundTripper
+	if opts.Replay != "" {
+		replayer, err := NewReplayer(opts.Replay, opts.Secrets)
+		if err != nil {
+			return err
+		}
+		rt = replayer
+	} else {
+		t, err := newTransport(opts)
+		if err != nil {
+			return err
+		}
+		rt = t
+		if opts.Record != "" {
+			rt = NewRecorder(opts.Record, t, opts.Secrets)
+		}
+	}
+	if opts.Debug != nil {
+		rt = &debugTransport{next: rt, w: opts.Debug, secrets: opts.Secrets}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	shared = rt
+	timeout = opts.Timeout
+	return nil
+}
+
+// Client returns a client over the shared transport that gives up on a
+// request after the configured timeout
+func Client() *http.Client {
+	rt, limit := current()
+	return &http.Client{Transport: rt, Timeout: limit}
+}
+
+// StreamClient returns a client over the shared transport for streamed
+// responses. Only the wait for the response headers is limited, as the body
+// may take minutes to arrive.
+func StreamClient() *http.Client {
+	rt, _ := current()
+	return &http.Client{Transport: rt}
+}
+
+// current returns the shared transport, creating the default one on first use
+func current() (http.RoundTripper, time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	if shared == nil {
+		// The default options need no files, so they can't fail
+		shared, _ = newTransport(Options{Timeout: defaultTimeout, ConnectTimeout: defaultConnectTimeout})
+	}
+	return shared, timeout
+}
+
+// newTransport builds a transport that honours HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY and keeps idle connections open for reuse
+func newTransport(opts Options) (*http.Transport, error) {
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", opts.CABundle)
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	return t, nil
+}
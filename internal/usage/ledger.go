@@ -0,0 +1,138 @@
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Entry is a line of the ledger, recording a single run of gopr
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Repo     string    `json:"repo"`
+	Branch   string    `json:"branch"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	// Attempts counts the requests of the run, including retries
+	Attempts     int `json:"attempts"`
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	// CacheReadTokens counts the input tokens read from the prompt cache
+	CacheReadTokens int `json:"cache_read_tokens,omitempty"`
+	// Cost is the estimated cost in US dollars, nil when the model has no
+	// price or the provider didn't report usage
+	Cost *float64 `json:"cost_usd,omitempty"`
+}
+
+// LedgerPath returns the location of the usage ledger
+func LedgerPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "gopr", "usage.jsonl"), nil
+}
+
+// Append adds an entry to the ledger, creating it if needed
+func Append(entry Entry) error {
+	path, err := LedgerPath()
+	if err != nil {
+		return fmt.Errorf("failed to locate usage ledger: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create usage ledger directory: %w", err)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage entry: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+	return nil
+}
+
+// ReadLedger returns the entries of the ledger at path, or none when it doesn't exist
+func ReadLedger(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid entry: %v", path, lineNo, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+	return entries, nil
+}
+
+// Summary adds up the entries sharing a key, such as a day or a model
+type Summary struct {
+	Key          string
+	Runs         int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+	// Unpriced counts the runs whose cost is unknown
+	Unpriced int
+}
+
+// Add counts an entry in the summary
+func (s *Summary) Add(entry Entry) {
+	s.Runs++
+	s.InputTokens += entry.InputTokens
+	s.OutputTokens += entry.OutputTokens
+	if entry.Cost != nil {
+		s.Cost += *entry.Cost
+	} else {
+		s.Unpriced++
+	}
+}
+
+// Summarize groups entries by the key that key returns, sorted by key
+func Summarize(entries []Entry, key func(Entry) string) []Summary {
+	index := make(map[string]int)
+	var summaries []Summary
+	for _, entry := range entries {
+		k := key(entry)
+		i, ok := index[k]
+		if !ok {
+			i = len(summaries)
+			index[k] = i
+			summaries = append(summaries, Summary{Key: k})
+		}
+		summaries[i].Add(entry)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Key < summaries[j].Key
+	})
+	return summaries
+}
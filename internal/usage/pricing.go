@@ -0,0 +1,57 @@
+package usage
+
+import (
+	"github.com/deleonn/gopr/internal/models"
+)
+
+// defaultPrices are the list prices of popular models in US dollars per
+// million tokens. They go out of date, so config files can override them
+// per model.
+var defaultPrices = map[string]models.Price{
+	"gpt-4":             {Input: 30, Output: 60},
+	"gpt-4-32k":         {Input: 60, Output: 120},
+	"gpt-4-turbo":       {Input: 10, Output: 30},
+	"gpt-4o":            {Input: 2.5, Output: 10},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.6},
+	"gpt-4.1":           {Input: 2, Output: 8},
+	"gpt-4.1-mini":      {Input: 0.4, Output: 1.6},
+	"gpt-3.5-turbo":     {Input: 0.5, Output: 1.5},
+	"claude-3-opus":     {Input: 15, Output: 75},
+	"claude-3-sonnet":   {Input: 3, Output: 15},
+	"claude-3-5-sonnet": {Input: 3, Output: 15},
+	"claude-3-7-sonnet": {Input: 3, Output: 15},
+	"claude-3-haiku":    {Input: 0.25, Output: 1.25},
+	"claude-3-5-haiku":  {Input: 0.8, Output: 4},
+	"deepseek-chat":     {Input: 0.27, Output: 1.1},
+	"deepseek-coder":    {Input: 0.27, Output: 1.1},
+	"deepseek-reasoner": {Input: 0.55, Output: 2.19},
+	"gemini-1.5-pro":    {Input: 1.25, Output: 5},
+	"gemini-1.5-flash":  {Input: 0.075, Output: 0.3},
+	"gemini-2.0-flash":  {Input: 0.1, Output: 0.4},
+}
+
+// LookupPrice returns the price of a model, preferring the overrides to the
+// built-in prices. Models are matched like models.LookupModel does. Models
+// run by Ollama, and the fake provider, are free.
+func LookupPrice(provider models.ProviderType, model string, overrides map[string]models.Price) (models.Price, bool) {
+	if price, ok := models.LookupModel(model, overrides); ok {
+		return price, true
+	}
+	if provider == models.ProviderOllama || provider == models.ProviderFake {
+		return models.Price{}, true
+	}
+	return models.LookupModel(model, defaultPrices)
+}
+
+// Prompt cache prices relative to the input price, as Anthropic charges them
+const (
+	cacheWriteRate = 1.25
+	cacheReadRate  = 0.1
+)
+
+// Cost returns the cost in US dollars of the given usage
+func Cost(price models.Price, usage models.Usage) float64 {
+	uncached := usage.InputTokens - usage.CacheReadTokens - usage.CacheWriteTokens
+	input := float64(uncached) + float64(usage.CacheWriteTokens)*cacheWriteRate + float64(usage.CacheReadTokens)*cacheReadRate
+	return (input*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6
+}